@@ -0,0 +1,159 @@
+package event
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type azureDevopsPayloadType struct {
+	EventType string
+	Resource  azureDevopsResource
+}
+
+type azureDevopsResource struct {
+	RefUpdates []azureDevopsRefUpdate
+	Commits    []azureDevopsCommit
+	Repository azureDevopsRepository
+	PushedBy   azureDevopsIdentity
+}
+
+type azureDevopsCommit struct {
+	CommitID string `json:"commitId" yaml:"commitId"`
+	Comment  string
+	URL      string
+	Author   azureDevopsCommitAuthor
+}
+
+type azureDevopsCommitAuthor struct {
+	Name  string
+	Email string
+	Date  string
+}
+
+type azureDevopsRefUpdate struct {
+	Name        string
+	OldObjectID string `json:"oldObjectId" yaml:"oldObjectId"`
+	NewObjectID string `json:"newObjectId" yaml:"newObjectId"`
+}
+
+type azureDevopsRepository struct {
+	Name      string
+	RemoteURL string `json:"remoteUrl" yaml:"remoteUrl"`
+	SSHURL    string `json:"sshUrl" yaml:"sshUrl"`
+	WebURL    string `json:"webUrl" yaml:"webUrl"`
+	Project   azureDevopsProject
+}
+
+type azureDevopsProject struct {
+	Name string
+}
+
+type azureDevopsIdentity struct {
+	UniqueName string
+}
+
+// NewAzureDevopsEvent takes an http.Request object and parses it corresponding
+// to Azure DevOps Services git.push service hook syntax into an RepoEvent object.
+// It returns a RepoEvent object and an error in case of error
+func NewAzureDevopsEvent(request *http.Request) (event *RepoEvent, err error) {
+	if request.Body == nil {
+		err = errors.New("Unable to parse request.Body == nil")
+		return
+	}
+	var parsedPayload azureDevopsPayloadType
+	var repoEvent RepoEvent
+	var kind, branch, author, commit string
+	err = json.NewDecoder(request.Body).Decode(&parsedPayload)
+
+	if err != nil {
+		return
+	}
+
+	switch parsedPayload.EventType {
+	case "git.push":
+	case "":
+		err = errors.New("Unable to parse event type")
+		return
+	default:
+		err = UnsupportedEventError{parsedPayload.EventType}
+		return
+	}
+
+	if len(parsedPayload.Resource.RefUpdates) > 0 {
+		refUpdate := parsedPayload.Resource.RefUpdates[0]
+		kind = KindPush
+		if strings.HasPrefix(refUpdate.Name, "refs/tags/") {
+			kind = KindTagPush
+		}
+		repoEvent.setRef(refUpdate.Name)
+		repoEvent.setSHAs(refUpdate.OldObjectID, refUpdate.NewObjectID)
+		repoEvent.Pusher = parsedPayload.Resource.PushedBy.UniqueName
+		for _, c := range parsedPayload.Resource.Commits {
+			repoEvent.Commits = append(repoEvent.Commits, Commit{
+				ID:        c.CommitID,
+				Message:   c.Comment,
+				Author:    c.Author.Name,
+				Email:     c.Author.Email,
+				Timestamp: c.Author.Date,
+				URL:       c.URL,
+			})
+		}
+		branch = repoEvent.Branch
+		commit = refUpdate.NewObjectID
+		author = parsedPayload.Resource.PushedBy.UniqueName
+		if repoEvent.Deleted {
+			commit = refUpdate.OldObjectID
+		}
+	} else {
+		err = errors.New("refUpdates array should contain at least 1 element, got 0")
+		return
+	}
+	if branch == "" {
+		err = errors.New("Unable to parse branch")
+	}
+	if commit == "" {
+		err = errors.New("Unable to parse commit")
+	}
+	if author == "" {
+		err = errors.New("Unable to parse author")
+	}
+	repoEvent.Kind = kind
+	repoEvent.Author = author
+	repoEvent.Branch = branch
+	repoEvent.Commit = commit
+	repoEvent.Repository = Repository{
+		Name:     parsedPayload.Resource.Repository.Name,
+		FullName: parsedPayload.Resource.Repository.Project.Name + "/" + parsedPayload.Resource.Repository.Name,
+		HTMLURL:  parsedPayload.Resource.Repository.WebURL,
+		CloneURL: parsedPayload.Resource.Repository.RemoteURL,
+		SSHURL:   parsedPayload.Resource.Repository.SSHURL,
+	}
+	event = &repoEvent
+	return
+}
+
+// ValidateAzureDevopsBasicAuth checks the basic authentication credentials sent by Azure DevOps
+// service hooks. secret must have the form username:password, if it does not contain any colon
+// only the password is checked. The comparison is done in constant time
+// It returns an error if the credentials are missing or do not match
+func ValidateAzureDevopsBasicAuth(request *http.Request, body []byte, secret string) (err error) {
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return MissingCredentialsError{"Basic authentication credentials not found in request"}
+	}
+	expectedPassword := secret
+	if i := strings.Index(secret, ":"); i >= 0 {
+		expectedUsername := secret[:i]
+		expectedPassword = secret[i+1:]
+		if subtle.ConstantTimeCompare([]byte(username), []byte(expectedUsername)) != 1 {
+			return errors.New("Basic authentication credentials do not match")
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) != 1 {
+		return errors.New("Basic authentication credentials do not match")
+	}
+	return
+}
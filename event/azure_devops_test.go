@@ -0,0 +1,120 @@
+package event
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureDevopsEventOK(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/azure-devops.json")
+	request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+
+	event, err := NewAzureDevopsEvent(request)
+	if err != nil {
+		t.Error("NewAzureDevopsEvent should not return err != nil")
+	}
+	if event.Author != "Windows Live ID\\fabrikamfiber4@hotmail.com" {
+		t.Error("event.Author must be Windows Live ID\\fabrikamfiber4@hotmail.com, got", event.Author)
+	}
+
+	if event.Branch != "master" {
+		t.Error("event.Branch must be master, got", event.Branch)
+	}
+
+	if event.Before != "aad331d8d3b131fa9ae03cf5e53965b51942618a" || event.After != "33b55f7cb7e7e245323987634f960cf4a6e6bc74" {
+		t.Errorf("event.Before and event.After do not match the payload, got %s and %s", event.Before, event.After)
+	}
+
+	if event.Repository.CloneURL != "https://fabrikam-fiber-inc.visualstudio.com/DefaultCollection/_git/Fabrikam-Fiber-Git" {
+		t.Errorf("event.Repository.CloneURL does not match the payload, got %s", event.Repository.CloneURL)
+	}
+
+	if len(event.Commits) != 1 || event.Commits[0].Message != "Fixed bug in web.config file" || event.Commits[0].Author != "Jamal Hartnett" {
+		t.Errorf("event.Commits does not match the payload, got %#v", event.Commits)
+	}
+
+	if event.Commit != "33b55f7cb7e7e245323987634f960cf4a6e6bc74" {
+		t.Error("event.Commit must be 33b55f7cb7e7e245323987634f960cf4a6e6bc74, got", event.Commit)
+	}
+
+	if event.Repository.FullName != "Fabrikam-Fiber/Fabrikam-Fiber-Git" {
+		t.Error("event.Repository.FullName must be Fabrikam-Fiber/Fabrikam-Fiber-Git, got", event.Repository.FullName)
+	}
+}
+
+func TestAzureDevopsEventKO(t *testing.T) {
+	request := httptest.NewRequest("POST", "/test", nil)
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err := NewAzureDevopsEvent(request)
+	if err == nil {
+		t.Error("NewAzureDevopsEvent should fail with payload = nil")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(""))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewAzureDevopsEvent(request)
+	if err == nil {
+		t.Error("NewAzureDevopsEvent should fail with payload = \"\"")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewAzureDevopsEvent(request)
+	if err == nil {
+		t.Error("NewAzureDevopsEvent should fail with payload = \"{}\"")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{\"eventType\": \"git.pullrequest.created\"}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewAzureDevopsEvent(request)
+	if err == nil {
+		t.Error("NewAzureDevopsEvent should fail with eventType != git.push")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{\"eventType\": \"git.push\", \"resource\": {\"refUpdates\": []}}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewAzureDevopsEvent(request)
+	if err == nil {
+		t.Error("NewAzureDevopsEvent should fail with empty refUpdates")
+	}
+}
+
+func TestValidateAzureDevopsBasicAuth(t *testing.T) {
+	testCases := []struct {
+		username string
+		password string
+		secret   string
+		err      bool
+	}{
+		{"githook", "my-password", "githook:my-password", false},
+		{"anyone", "my-password", "my-password", false},
+		{"githook", "pass:with:colons", "githook:pass:with:colons", false},
+		{"another", "my-password", "githook:my-password", true},
+		{"githook", "another-password", "githook:my-password", true},
+		{"githook", "another-password", "my-password", true},
+		{"", "", "githook:my-password", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+		request.Header.Set("Content-Type", "application/json")
+		if test.username != "" || test.password != "" {
+			request.SetBasicAuth(test.username, test.password)
+		}
+
+		err := ValidateAzureDevopsBasicAuth(request, []byte("{}"), test.secret)
+		if test.err && err == nil {
+			t.Errorf("%02d. ValidateAzureDevopsBasicAuth should fail with %s:%s", i, test.username, test.password)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. ValidateAzureDevopsBasicAuth should not fail with %s:%s, got %s", i, test.username, test.password, err)
+		}
+	}
+}
@@ -4,10 +4,28 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 )
 
 type bitbucketPayloadType struct {
-	Push push
+	Push        push
+	Pullrequest bitbucketPullrequest
+	Actor       actor
+	Repository  bitbucketRepository
+}
+
+type bitbucketRepository struct {
+	Name     string
+	FullName string `json:"full_name" yaml:"full_name"`
+	Links    bitbucketLinks
+}
+
+type bitbucketLinks struct {
+	HTML bitbucketLink
+}
+
+type bitbucketLink struct {
+	Href string
 }
 
 type push struct {
@@ -15,8 +33,9 @@ type push struct {
 }
 
 type change struct {
-	Old changeStruct
-	New changeStruct
+	Old     changeStruct
+	New     changeStruct
+	Commits []target
 }
 
 type changeStruct struct {
@@ -26,11 +45,15 @@ type changeStruct struct {
 }
 
 type target struct {
-	Hash   string
-	Author author
+	Hash    string
+	Message string
+	Date    string
+	Author  author
+	Links   bitbucketLinks
 }
 
 type author struct {
+	Raw  string
 	User actor
 }
 
@@ -38,8 +61,26 @@ type actor struct {
 	Username string
 }
 
+type bitbucketPullrequest struct {
+	Source bitbucketPullrequestSource
+}
+
+type bitbucketPullrequestSource struct {
+	Branch bitbucketBranch
+	Commit bitbucketCommit
+}
+
+type bitbucketBranch struct {
+	Name string
+}
+
+type bitbucketCommit struct {
+	Hash string
+}
+
 // NewBitbucketEvent takes an http.Request object and parses it corresponding
 // to Bitbucket webhook syntax into an RepoEvent object.
+// The event kind is read from X-Event-Key header, repo:push is assumed if it is not present
 // It returns a RepoEvent object and an error in case of error
 func NewBitbucketEvent(request *http.Request) (event *RepoEvent, err error) {
 	if request.Body == nil {
@@ -47,20 +88,60 @@ func NewBitbucketEvent(request *http.Request) (event *RepoEvent, err error) {
 		return
 	}
 	var parsedPayload bitbucketPayloadType
-	var branch, author, commit string
+	var repoEvent RepoEvent
+	var kind, branch, author, commit string
 	err = json.NewDecoder(request.Body).Decode(&parsedPayload)
 
 	if err != nil {
 		return
 	}
 
-	if len(parsedPayload.Push.Changes) > 0 {
-		branch = parsedPayload.Push.Changes[0].New.Name
-		commit = parsedPayload.Push.Changes[0].New.Target.Hash
-		author = parsedPayload.Push.Changes[0].New.Target.Author.User.Username
-	} else {
-		err = errors.New("Changes array should contain at least 1 element, got 0")
-		return
+	eventKey := request.Header.Get("X-Event-Key")
+	switch {
+	case eventKey == "" || eventKey == "repo:push":
+		if len(parsedPayload.Push.Changes) == 0 {
+			err = errors.New("Changes array should contain at least 1 element, got 0")
+			return
+		}
+		pushChange := parsedPayload.Push.Changes[0]
+		ref := pushChange.New
+		if ref.Name == "" {
+			// New is null when the branch or tag is deleted
+			ref = pushChange.Old
+		}
+		kind = KindPush
+		if ref.Type == "tag" || ref.Type == "annotated_tag" {
+			kind = KindTagPush
+			repoEvent.setRef("refs/tags/" + ref.Name)
+		} else {
+			repoEvent.setRef("refs/heads/" + ref.Name)
+		}
+		repoEvent.setSHAs(pushChange.Old.Target.Hash, pushChange.New.Target.Hash)
+		repoEvent.Pusher = parsedPayload.Actor.Username
+		for _, c := range pushChange.Commits {
+			name, email := splitRawAuthor(c.Author.Raw)
+			repoEvent.Commits = append(repoEvent.Commits, Commit{
+				ID:        c.Hash,
+				Message:   c.Message,
+				Author:    name,
+				Email:     email,
+				Timestamp: c.Date,
+				URL:       c.Links.HTML.Href,
+			})
+		}
+		branch = repoEvent.Branch
+		commit = ref.Target.Hash
+		author = ref.Target.Author.User.Username
+		if author == "" {
+			author = repoEvent.Pusher
+		}
+	case strings.HasPrefix(eventKey, "pullrequest:"):
+		kind = KindPullRequest
+		branch = parsedPayload.Pullrequest.Source.Branch.Name
+		commit = parsedPayload.Pullrequest.Source.Commit.Hash
+		author = parsedPayload.Actor.Username
+	default:
+		return nil, UnsupportedEventError{eventKey}
 	}
 	if branch == "" {
 		err = errors.New("Unable to parse branch")
@@ -71,6 +152,35 @@ func NewBitbucketEvent(request *http.Request) (event *RepoEvent, err error) {
 	if author == "" {
 		err = errors.New("Unable to parse author")
 	}
-	event = &RepoEvent{Author: author, Branch: branch, Commit: commit}
+	repoEvent.Kind = kind
+	repoEvent.Author = author
+	repoEvent.Branch = branch
+	repoEvent.Commit = commit
+	repoEvent.Repository = Repository{
+		Name:     parsedPayload.Repository.Name,
+		FullName: parsedPayload.Repository.FullName,
+		HTMLURL:  parsedPayload.Repository.Links.HTML.Href,
+	}
+	if parsedPayload.Repository.Links.HTML.Href != "" {
+		repoEvent.Repository.CloneURL = parsedPayload.Repository.Links.HTML.Href + ".git"
+	}
+	event = &repoEvent
 	return
 }
+
+// splitRawAuthor splits a git author with the syntax `Name <email>` into its name and email
+func splitRawAuthor(raw string) (name, email string) {
+	start := strings.LastIndex(raw, "<")
+	end := strings.LastIndex(raw, ">")
+	if start < 0 || end < start {
+		return strings.TrimSpace(raw), ""
+	}
+	return strings.TrimSpace(raw[:start]), raw[start+1 : end]
+}
+
+// ValidateBitbucketSignature checks the HMAC signature of the request body sent by Bitbucket
+// at X-Hub-Signature header. Bitbucket Cloud and Bitbucket Server share the same signature syntax.
+// It returns an error if the signature is missing or does not match
+func ValidateBitbucketSignature(request *http.Request, body []byte, secret string) (err error) {
+	return validateHMAC(request.Header.Get("X-Hub-Signature"), body, secret)
+}
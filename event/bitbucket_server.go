@@ -0,0 +1,149 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type bitbucketServerPayloadType struct {
+	EventKey    string
+	Actor       bitbucketServerActor
+	Repository  bitbucketServerRepository
+	Changes     []bitbucketServerChange
+	PullRequest bitbucketServerPullRequest
+}
+
+type bitbucketServerActor struct {
+	Name string
+}
+
+type bitbucketServerRepository struct {
+	Slug    string
+	Name    string
+	Project bitbucketServerProject
+	Links   bitbucketServerLinks
+}
+
+type bitbucketServerLinks struct {
+	Clone []bitbucketServerLink
+	Self  []bitbucketServerLink
+}
+
+type bitbucketServerLink struct {
+	Href string
+	Name string
+}
+
+type bitbucketServerProject struct {
+	Key string
+}
+
+type bitbucketServerChange struct {
+	Ref      bitbucketServerRef
+	FromHash string
+	ToHash   string
+	Type     string
+}
+
+type bitbucketServerRef struct {
+	ID           string
+	DisplayID    string
+	Type         string
+	LatestCommit string
+	Repository   bitbucketServerRepository
+}
+
+type bitbucketServerPullRequest struct {
+	FromRef bitbucketServerRef
+	ToRef   bitbucketServerRef
+}
+
+// NewBitbucketServerEvent takes an http.Request object and parses it corresponding
+// to Bitbucket Server (and Data Center) webhook syntax into an RepoEvent object.
+// The event kind is read from X-Event-Key header (or eventKey payload field), supported events
+// are repo:refs_changed, pr:* and diagnostics:ping.
+// It returns a RepoEvent object and an error in case of error
+func NewBitbucketServerEvent(request *http.Request) (event *RepoEvent, err error) {
+	if request.Body == nil {
+		err = errors.New("Unable to parse request.Body == nil")
+		return
+	}
+	var parsedPayload bitbucketServerPayloadType
+	var repoEvent RepoEvent
+	var kind, branch, author, commit string
+	err = json.NewDecoder(request.Body).Decode(&parsedPayload)
+
+	if err != nil {
+		return
+	}
+
+	eventKey := request.Header.Get("X-Event-Key")
+	if eventKey == "" {
+		eventKey = parsedPayload.EventKey
+	}
+	repository := parsedPayload.Repository
+	switch {
+	case eventKey == "diagnostics:ping":
+		return &RepoEvent{Kind: KindPing}, nil
+	case eventKey == "repo:refs_changed":
+		if len(parsedPayload.Changes) == 0 {
+			err = errors.New("Changes array should contain at least 1 element, got 0")
+			return
+		}
+		refChange := parsedPayload.Changes[0]
+		kind = KindPush
+		if refChange.Ref.Type == "TAG" {
+			kind = KindTagPush
+		}
+		repoEvent.setRef(refChange.Ref.ID)
+		repoEvent.setSHAs(refChange.FromHash, refChange.ToHash)
+		repoEvent.Pusher = parsedPayload.Actor.Name
+		branch = refChange.Ref.DisplayID
+		commit = refChange.ToHash
+		author = parsedPayload.Actor.Name
+		if repoEvent.Deleted {
+			commit = refChange.FromHash
+		}
+	case strings.HasPrefix(eventKey, "pr:"):
+		kind = KindPullRequest
+		branch = parsedPayload.PullRequest.FromRef.DisplayID
+		commit = parsedPayload.PullRequest.FromRef.LatestCommit
+		author = parsedPayload.Actor.Name
+		repository = parsedPayload.PullRequest.ToRef.Repository
+	default:
+		err = UnsupportedEventError{eventKey}
+		return
+	}
+	if branch == "" {
+		err = errors.New("Unable to parse branch")
+	}
+	if commit == "" {
+		err = errors.New("Unable to parse commit")
+	}
+	if author == "" {
+		err = errors.New("Unable to parse author")
+	}
+	repoEvent.Kind = kind
+	repoEvent.Author = author
+	repoEvent.Branch = branch
+	repoEvent.Commit = commit
+	repoEvent.Repository = Repository{
+		Name:     repository.Slug,
+		FullName: repository.Project.Key + "/" + repository.Slug,
+	}
+	for _, link := range repository.Links.Clone {
+		switch link.Name {
+		case "http", "https":
+			repoEvent.Repository.CloneURL = link.Href
+		case "ssh":
+			repoEvent.Repository.SSHURL = link.Href
+		}
+	}
+	if len(repository.Links.Self) > 0 {
+		repoEvent.Repository.HTMLURL = repository.Links.Self[0].Href
+	}
+	event = &repoEvent
+	return
+}
@@ -0,0 +1,185 @@
+package event
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBitbucketServerEventOK(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/bitbucket-server.json")
+	request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Event-Key", "repo:refs_changed")
+
+	event, err := NewBitbucketServerEvent(request)
+	if err != nil {
+		t.Error("NewBitbucketServerEvent should not return err != nil")
+	}
+	if event.Author != "admin" {
+		t.Error("event.Author must be admin, got", event.Author)
+	}
+
+	if event.Branch != "feature/new-api" {
+		t.Error("event.Branch must be feature/new-api, got", event.Branch)
+	}
+
+	if event.Ref != "refs/heads/feature/new-api" || event.Before != "ecddabb624f6f5ba43816f5926e580a5f680a932" || event.After != "178864a7d521b6f5e720b386b2c2b0ef8563e0dc" {
+		t.Errorf("event.Ref, event.Before and event.After do not match the payload, got %s, %s and %s", event.Ref, event.Before, event.After)
+	}
+
+	if event.Pusher != "admin" || event.Created || event.Deleted {
+		t.Errorf("event.Pusher must be admin and the branch neither created nor deleted, got %#v", event)
+	}
+
+	if event.Commit != "178864a7d521b6f5e720b386b2c2b0ef8563e0dc" {
+		t.Error("event.Commit must be 178864a7d521b6f5e720b386b2c2b0ef8563e0dc, got", event.Commit)
+	}
+
+	if event.Repository.FullName != "PROJ/repository" {
+		t.Error("event.Repository.FullName must be PROJ/repository, got", event.Repository.FullName)
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewBitbucketServerEvent(request)
+	if err != nil {
+		t.Error("NewBitbucketServerEvent should fallback to payload eventKey when X-Event-Key is not present")
+	}
+}
+
+func TestBitbucketServerEventKO(t *testing.T) {
+	request := httptest.NewRequest("POST", "/test", nil)
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err := NewBitbucketServerEvent(request)
+	if err == nil {
+		t.Error("NewBitbucketServerEvent should fail with payload = nil")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(""))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewBitbucketServerEvent(request)
+	if err == nil {
+		t.Error("NewBitbucketServerEvent should fail with payload = \"\"")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewBitbucketServerEvent(request)
+	if err == nil {
+		t.Error("NewBitbucketServerEvent should fail with payload = \"{}\"")
+	}
+
+	payload, _ := ioutil.ReadFile("../payloads/bitbucket-server.json")
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Event-Key", "pr:comment:added")
+
+	_, err = NewBitbucketServerEvent(request)
+	if err == nil {
+		t.Error("NewBitbucketServerEvent should fail with pr:* X-Event-Key without pullRequest")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Event-Key", "repo:forked")
+
+	_, err = NewBitbucketServerEvent(request)
+	if err == nil {
+		t.Error("NewBitbucketServerEvent should fail with unsupported X-Event-Key")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{\"eventKey\": \"repo:refs_changed\", \"changes\": []}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewBitbucketServerEvent(request)
+	if err == nil {
+		t.Error("NewBitbucketServerEvent should fail with empty changes")
+	}
+}
+
+func TestBitbucketServerParser(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/bitbucket-server.json")
+	secret := "my-bitbucket-server-secret"
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	parser, found := Lookup("bitbucket-server")
+	if !found {
+		t.Fatal("bitbucket-server parser must be registered")
+	}
+	validator, ok := parser.(Validator)
+	if !ok {
+		t.Fatal("bitbucket-server parser must implement Validator")
+	}
+
+	request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	if err := validator.Validate(request, payload, secret); err != nil {
+		t.Errorf("bitbucket-server parser should validate X-Hub-Signature, got %s", err)
+	}
+	if err := validator.Validate(request, payload, "another-secret"); err == nil {
+		t.Errorf("bitbucket-server parser should fail validation with another secret")
+	}
+}
+
+func TestBitbucketServerEventKinds(t *testing.T) {
+	push, _ := ioutil.ReadFile("../payloads/bitbucket-server.json")
+	tagPush := strings.Replace(string(push), `"type": "BRANCH"`, `"type": "TAG"`, 1)
+	pullRequest := `{
+		"eventKey": "pr:opened",
+		"actor": {"name": "admin"},
+		"pullRequest": {
+			"fromRef": {"displayId": "feature/a", "latestCommit": "ef8755f06ee4b28c96a847a95cb8ec8ed6ddd1ca", "repository": {"slug": "fork", "project": {"key": "~ADMIN"}}},
+			"toRef": {"displayId": "master", "latestCommit": "178864a7d521b6f5e720b386b2c2b0ef8563e0dc", "repository": {"slug": "repository", "project": {"key": "PROJ"}}}
+		}
+	}`
+
+	testCases := []struct {
+		eventKey string
+		payload  string
+		kind     string
+		branch   string
+		commit   string
+		err      bool
+	}{
+		{"repo:refs_changed", string(push), KindPush, "feature/new-api", "178864a7d521b6f5e720b386b2c2b0ef8563e0dc", false},
+		{"repo:refs_changed", tagPush, KindTagPush, "feature/new-api", "178864a7d521b6f5e720b386b2c2b0ef8563e0dc", false},
+		{"pr:opened", pullRequest, KindPullRequest, "feature/a", "ef8755f06ee4b28c96a847a95cb8ec8ed6ddd1ca", false},
+		{"diagnostics:ping", "{}", KindPing, "", "", false},
+		{"pr:opened", string(push), "", "", "", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Event-Key", test.eventKey)
+
+		event, err := NewBitbucketServerEvent(request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. NewBitbucketServerEvent should fail with X-Event-Key %s", i, test.eventKey)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. NewBitbucketServerEvent should not fail with X-Event-Key %s, got %s", i, test.eventKey, err)
+			continue
+		}
+		if event.Kind != test.kind || event.Branch != test.branch || event.Commit != test.commit {
+			t.Errorf("%02d. NewBitbucketServerEvent returned %#v, expected kind %s, branch %s and commit %s", i, event, test.kind, test.branch, test.commit)
+		}
+		if test.kind == KindPullRequest && event.Repository.FullName != "PROJ/repository" {
+			t.Errorf("%02d. NewBitbucketServerEvent repository must be the pull request target, got %s", i, event.Repository.FullName)
+		}
+	}
+}
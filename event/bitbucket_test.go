@@ -1,6 +1,9 @@
 package event
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
 	"net/http/httptest"
 	"strings"
@@ -24,6 +27,18 @@ func TestBitbucketEventOK(t *testing.T) {
 		t.Error("event.Branch must be master, got", event.Author)
 	}
 
+	if event.Ref != "refs/heads/master" || event.Before != "28f09ec0349f814711f282ccdf279087bf21c6cc" || event.Pusher != "vcabezas" {
+		t.Errorf("event.Ref, event.Before and event.Pusher do not match the payload, got %s, %s and %s", event.Ref, event.Before, event.Pusher)
+	}
+
+	if event.Repository.FullName != "vcabezas/test-webhook-repo" || event.Repository.CloneURL != "https://bitbucket.org/vcabezas/test-webhook-repo.git" {
+		t.Errorf("event.Repository does not match the payload, got %#v", event.Repository)
+	}
+
+	if len(event.Commits) == 0 || event.Commits[0].Author != "Victor Cabezas Lucena" || event.Commits[0].Email != "wiston666@gmail.com" {
+		t.Errorf("event.Commits does not match the payload, got %#v", event.Commits)
+	}
+
 	if event.Commit != "ffcc6f559d9be8124711e94349c4fe26642d762b" {
 		t.Error("event.Commit must be ffcc6f559d9be8124711e94349c4fe26642d762b, got", event.Author)
 	}
@@ -86,3 +101,106 @@ func TestBitbucketEventKO(t *testing.T) {
 		t.Error("NewBitbucketEvent should fail request method != POST")
 	}
 }
+
+func TestValidateBitbucketSignature(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/bitbucket.org.json")
+	secret := "my-bitbucket-secret"
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	testCases := []struct {
+		header    string
+		signature string
+		secret    string
+		err       bool
+	}{
+		{"X-Hub-Signature", signature, secret, false},
+		{"X-Hub-Signature", signature, "another-secret", true},
+		{"X-Hub-Signature", "sha256=0123456789abcdef", secret, true},
+		{"X-Hub-Signature", "", secret, true},
+		{"X-Hub-Signature-256", signature, secret, true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(test.header, test.signature)
+
+		err := ValidateBitbucketSignature(request, payload, test.secret)
+		if test.err && err == nil {
+			t.Errorf("%02d. ValidateBitbucketSignature should fail with %s: %s", i, test.header, test.signature)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. ValidateBitbucketSignature should not fail with %s: %s, got %s", i, test.header, test.signature, err)
+		}
+	}
+}
+
+func TestBitbucketEventKinds(t *testing.T) {
+	push, _ := ioutil.ReadFile("../payloads/bitbucket.org.json")
+	pullRequest, _ := ioutil.ReadFile("../payloads/bitbucket.org-pullrequest.json")
+	tagPush := strings.Replace(string(push), `"type": "branch"`, `"type": "tag"`, -1)
+	annotatedTagPush := strings.Replace(string(push), `"type": "branch"`, `"type": "annotated_tag"`, -1)
+
+	testCases := []struct {
+		eventKey string
+		payload  string
+		kind     string
+		branch   string
+		commit   string
+		err      bool
+	}{
+		{"", string(push), KindPush, "master", "ffcc6f559d9be8124711e94349c4fe26642d762b", false},
+		{"repo:push", string(push), KindPush, "master", "ffcc6f559d9be8124711e94349c4fe26642d762b", false},
+		{"repo:push", tagPush, KindTagPush, "master", "ffcc6f559d9be8124711e94349c4fe26642d762b", false},
+		{"repo:push", annotatedTagPush, KindTagPush, "master", "ffcc6f559d9be8124711e94349c4fe26642d762b", false},
+		{"pullrequest:created", string(pullRequest), KindPullRequest, "bugfix/readme-typos", "1e2c3a4b5d6e", false},
+		{"pullrequest:updated", string(pullRequest), KindPullRequest, "bugfix/readme-typos", "1e2c3a4b5d6e", false},
+		{"pullrequest:created", string(push), "", "", "", true},
+		{"repo:fork", string(push), "", "", "", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Event-Key", test.eventKey)
+
+		event, err := NewBitbucketEvent(request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. NewBitbucketEvent should fail with X-Event-Key %s", i, test.eventKey)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. NewBitbucketEvent should not fail with X-Event-Key %s, got %s", i, test.eventKey, err)
+			continue
+		}
+		if test.kind == KindTagPush && (event.Tag != test.branch || event.Ref != "refs/tags/"+test.branch) {
+			t.Errorf("%02d. NewBitbucketEvent tag pushes must set the tag reference, got %#v", i, event)
+		}
+		if event.Kind != test.kind || event.Branch != test.branch || event.Commit != test.commit {
+			t.Errorf("%02d. NewBitbucketEvent returned %#v, expected kind %s, branch %s and commit %s", i, event, test.kind, test.branch, test.commit)
+		}
+	}
+}
+
+func TestSplitRawAuthor(t *testing.T) {
+	testCases := []struct {
+		raw   string
+		name  string
+		email string
+	}{
+		{"Victor Cabezas Lucena <wiston666@gmail.com>", "Victor Cabezas Lucena", "wiston666@gmail.com"},
+		{"Victor Cabezas Lucena", "Victor Cabezas Lucena", ""},
+		{"", "", ""},
+	}
+
+	for i, test := range testCases {
+		name, email := splitRawAuthor(test.raw)
+		if name != test.name || email != test.email {
+			t.Errorf("%02d. splitRawAuthor(%s) returned %s and %s, expected %s and %s", i, test.raw, name, email, test.name, test.email)
+		}
+	}
+}
@@ -1,5 +1,8 @@
 // Package event contains all the needed parsing for several
 // repository provider's hooks
 //
-// Currently it supports Github and Bitbucket
+// Currently it supports Github, Gitlab, Bitbucket (Cloud and Server), Gitea (also Gogs and Forgejo)
+// and Azure DevOps. A generic parser, which extracts the event fields from any JSON body,
+// is also available (see NewGenericParser). Parsers for other providers can be added implementing the Parser interface
+// and registering them using Register
 package event
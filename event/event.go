@@ -1,8 +1,173 @@
 package event
 
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Kinds of events a RepoEvent can represent
+const (
+	// KindPush is a push of commits to a branch
+	KindPush = "push"
+	// KindTagPush is a push of a tag
+	KindTagPush = "tag_push"
+	// KindPullRequest is a pull request event, Branch holds the source branch
+	KindPullRequest = "pull_request"
+	// KindMergeRequest is a Gitlab merge request event, Branch holds the source branch
+	KindMergeRequest = "merge_request"
+	// KindRelease is a release event, Branch holds the release tag
+	KindRelease = "release"
+	// KindPing is sent by some providers when the webhook is created
+	KindPing = "ping"
+)
+
+// UnsupportedEventError is returned by the parsers when the request holds a kind of event they do not handle,
+// i.e.: GitHub issues or create events
+type UnsupportedEventError struct {
+	Event string
+}
+
+// Error implements error
+func (e UnsupportedEventError) Error() string {
+	return fmt.Sprintf("Unsupported event %q", e.Event)
+}
+
+// zeroSHA is sent by the providers as before (after) commit when a ref is created (deleted)
+const zeroSHA = "0000000000000000000000000000000000000000"
+
 // RepoEvent stores relevant information about a repository when an event is received
+// Kind holds the kind of event received, one of the Kind* constants
+// Ref holds the full git reference (i.e.: refs/heads/feature/foo) for push events, Branch holds the
+// short reference name (i.e.: feature/foo) and Tag is filled too when the reference is a tag.
+// Commit holds the head commit SHA, or the previous one when the reference is deleted. It is empty for
+// GitHub, Gitea, Gogs and Forgejo releases, whose payload does not include the tagged commit.
+// Author holds the author of the head commit (or the user triggering the event when it is not available)
+// while Pusher holds the user who pushed the commits
+// Before and After hold the reference SHAs before and after a push, Created and Deleted are set
+// when the push created or deleted the reference
+// ChangedPaths holds the files added, modified or removed by the pushed commits when the provider
+// sends that information
+// Payload and Headers hold the decoded request body and headers, they are filled by SetRequest
+// so provider specific data is available to the hook commands, they are not JSON encoded
 type RepoEvent struct {
-	Author string
-	Branch string
-	Commit string
+	Kind         string                 `json:"kind"`
+	Author       string                 `json:"author"`
+	Pusher       string                 `json:"pusher,omitempty"`
+	Ref          string                 `json:"ref,omitempty"`
+	Branch       string                 `json:"branch"`
+	Tag          string                 `json:"tag,omitempty"`
+	Commit       string                 `json:"commit"`
+	Before       string                 `json:"before,omitempty"`
+	After        string                 `json:"after,omitempty"`
+	Created      bool                   `json:"created,omitempty"`
+	Deleted      bool                   `json:"deleted,omitempty"`
+	Repository   Repository             `json:"repository"`
+	Commits      []Commit               `json:"commits,omitempty"`
+	ChangedPaths []string               `json:"changed_paths,omitempty"`
+	Payload      map[string]interface{} `json:"-"`
+	Headers      http.Header            `json:"-"`
+}
+
+// Repository stores information about the repository which originated the event
+type Repository struct {
+	Name     string `json:"name,omitempty"`
+	FullName string `json:"full_name,omitempty"`
+	HTMLURL  string `json:"html_url,omitempty"`
+	CloneURL string `json:"clone_url,omitempty"`
+	SSHURL   string `json:"ssh_url,omitempty"`
+}
+
+// Commit stores information about a commit included in the event
+type Commit struct {
+	ID        string   `json:"id"`
+	Message   string   `json:"message,omitempty"`
+	Author    string   `json:"author,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Timestamp string   `json:"timestamp,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Modified  []string `json:"modified,omitempty"`
+}
+
+// setRef fills Ref, Branch and Tag from a full git reference
+func (e *RepoEvent) setRef(ref string) {
+	e.Ref = ref
+	e.Branch = shortRef(ref)
+	if strings.HasPrefix(ref, "refs/tags/") {
+		e.Tag = e.Branch
+	}
+}
+
+// setSHAs fills Before and After, Created and Deleted are set when before (after) is empty or zeroSHA
+func (e *RepoEvent) setSHAs(before, after string) {
+	e.Before = before
+	e.After = after
+	e.Created = before == "" || before == zeroSHA
+	e.Deleted = after == "" || after == zeroSHA
+}
+
+// shortRef removes refs/heads/ or refs/tags/ prefixes from a git reference,
+// the rest of the reference is kept so feature/foo is not truncated into foo
+func shortRef(ref string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return ref
+}
+
+// jsonCommit holds the commit syntax shared by the providers using the same
+// payload layout (Github, Gitlab and Gitea)
+type jsonCommit struct {
+	ID        string
+	Message   string
+	Timestamp string
+	URL       string
+	Author    jsonCommitAuthor
+	Added     []string
+	Removed   []string
+	Modified  []string
+}
+
+type jsonCommitAuthor struct {
+	Name     string
+	Email    string
+	Username string
+}
+
+// commits converts the payload commits into Commit objects
+func commits(jsonCommits []jsonCommit) (result []Commit) {
+	for _, c := range jsonCommits {
+		result = append(result, Commit{
+			ID:        c.ID,
+			Message:   c.Message,
+			Author:    c.Author.Name,
+			Email:     c.Author.Email,
+			Timestamp: c.Timestamp,
+			URL:       c.URL,
+			Added:     c.Added,
+			Removed:   c.Removed,
+			Modified:  c.Modified,
+		})
+	}
+	return
+}
+
+// changedPaths returns the deduplicated list of files changed by commits
+func changedPaths(commits []Commit) (paths []string) {
+	seen := make(map[string]bool)
+	for _, commit := range commits {
+		for _, files := range [][]string{commit.Added, commit.Modified, commit.Removed} {
+			for _, file := range files {
+				if !seen[file] {
+					seen[file] = true
+					paths = append(paths, file)
+				}
+			}
+		}
+	}
+	return
 }
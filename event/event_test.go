@@ -0,0 +1,51 @@
+package event
+
+import (
+	"testing"
+)
+
+func TestSetRef(t *testing.T) {
+	testCases := []struct {
+		ref    string
+		branch string
+		tag    string
+	}{
+		{"refs/heads/master", "master", ""},
+		{"refs/heads/feature/foo", "feature/foo", ""},
+		{"refs/tags/v1.0.0", "v1.0.0", "v1.0.0"},
+		{"refs/tags/release/v1.0.0", "release/v1.0.0", "release/v1.0.0"},
+		{"master", "master", ""},
+		{"", "", ""},
+	}
+
+	for i, test := range testCases {
+		var event RepoEvent
+		event.setRef(test.ref)
+		if event.Ref != test.ref || event.Branch != test.branch || event.Tag != test.tag {
+			t.Errorf("%02d. setRef(%s) returned %#v, expected branch %s and tag %s", i, test.ref, event, test.branch, test.tag)
+		}
+	}
+}
+
+func TestSetSHAs(t *testing.T) {
+	testCases := []struct {
+		before  string
+		after   string
+		created bool
+		deleted bool
+	}{
+		{"2e8b7f0b9272b7374dadb6f13100a36bfe03eceb", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false, false},
+		{zeroSHA, "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", true, false},
+		{"", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", true, false},
+		{"2e8b7f0b9272b7374dadb6f13100a36bfe03eceb", zeroSHA, false, true},
+		{"2e8b7f0b9272b7374dadb6f13100a36bfe03eceb", "", false, true},
+	}
+
+	for i, test := range testCases {
+		var event RepoEvent
+		event.setSHAs(test.before, test.after)
+		if event.Created != test.created || event.Deleted != test.deleted {
+			t.Errorf("%02d. setSHAs(%s, %s) returned created %v and deleted %v, expected %v and %v", i, test.before, test.after, event.Created, event.Deleted, test.created, test.deleted)
+		}
+	}
+}
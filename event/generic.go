@@ -0,0 +1,211 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// genericFields maps the generic parser options to the RepoEvent field they set
+var genericFields = map[string]func(event *RepoEvent, value string){
+	"after":           func(event *RepoEvent, value string) { event.After = value },
+	"author":          func(event *RepoEvent, value string) { event.Author = value },
+	"before":          func(event *RepoEvent, value string) { event.Before = value },
+	"branch":          func(event *RepoEvent, value string) { event.Branch = value },
+	"clone_url":       func(event *RepoEvent, value string) { event.Repository.CloneURL = value },
+	"commit":          func(event *RepoEvent, value string) { event.Commit = value },
+	"kind":            func(event *RepoEvent, value string) { event.Kind = value },
+	"pusher":          func(event *RepoEvent, value string) { event.Pusher = value },
+	"ref":             func(event *RepoEvent, value string) { event.Ref = value },
+	"repository":      func(event *RepoEvent, value string) { event.Repository.FullName = value },
+	"repository_name": func(event *RepoEvent, value string) { event.Repository.Name = value },
+	"tag":             func(event *RepoEvent, value string) { event.Tag = value },
+}
+
+type genericField struct {
+	name  string
+	path  string
+	regex *regexp.Regexp
+}
+
+type genericParser struct {
+	fields []genericField
+}
+
+// NewGenericParser creates a Parser that extracts the RepoEvent fields from any JSON body
+// using the expressions given at options. Each RepoEvent field (after, author, before, branch, clone_url,
+// commit, kind, pusher, ref, repository, repository_name and tag) is mapped to a JSONPath-like expression, i.e.: `branch: $.ref`, and can be
+// optionally post-processed by a regular expression given at <field>_regex, in which case the first
+// capture group (or the whole match if there are no groups) is used as value, i.e.:
+// `branch_regex: ^refs/heads/(.+)$`. branch expression is mandatory and kind is push if not defined.
+// It returns the configured Parser and an error in case of invalid options
+func NewGenericParser(options map[string]string) (parser Parser, err error) {
+	var fields []genericField
+	for key, value := range options {
+		name := strings.TrimSuffix(key, "_regex")
+		if _, ok := genericFields[name]; !ok {
+			return nil, fmt.Errorf("Unknown generic option %s", key)
+		}
+		if name != key {
+			if _, ok := options[name]; !ok {
+				return nil, fmt.Errorf("Option %s defined without %s expression", key, name)
+			}
+			continue
+		}
+		if _, err = splitPath(value); err != nil {
+			return nil, fmt.Errorf("Invalid %s expression: %s", name, err)
+		}
+		field := genericField{name: name, path: value}
+		if expr, ok := options[name+"_regex"]; ok {
+			if field.regex, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("Invalid %s_regex: %s", name, err)
+			}
+		}
+		fields = append(fields, field)
+	}
+	if _, ok := options["branch"]; !ok {
+		return nil, errors.New("Option branch is mandatory")
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return genericParser{fields: fields}, nil
+}
+
+// Configure implements Configurable
+func (p genericParser) Configure(options map[string]string) (parser Parser, err error) {
+	return NewGenericParser(options)
+}
+
+// Parse implements Parser
+func (p genericParser) Parse(request *http.Request) (event *RepoEvent, err error) {
+	if len(p.fields) == 0 {
+		err = errors.New("Generic parser must be configured using hook options")
+		return
+	}
+	if request.Body == nil {
+		err = errors.New("Unable to parse request.Body == nil")
+		return
+	}
+	var parsedPayload interface{}
+	decoder := json.NewDecoder(request.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&parsedPayload)
+	if err != nil {
+		return
+	}
+
+	event = &RepoEvent{Kind: KindPush}
+	for _, field := range p.fields {
+		value, lookupErr := lookupPath(parsedPayload, field.path)
+		if lookupErr != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %s", field.name, lookupErr)
+		}
+		str := stringValue(value)
+		if field.regex != nil {
+			match := field.regex.FindStringSubmatch(str)
+			switch {
+			case match == nil:
+				str = ""
+			case len(match) > 1:
+				str = match[1]
+			default:
+				str = match[0]
+			}
+		}
+		if str == "" {
+			return nil, fmt.Errorf("Unable to parse %s", field.name)
+		}
+		genericFields[field.name](event, str)
+	}
+	return
+}
+
+// splitPath splits a JSONPath-like expression into its keys. Both JSONPath ($.commits[0].id,
+// $['head_commit']['id']) and gjson (commits.0.id) syntax are supported
+func splitPath(path string) (keys []string, err error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	for len(path) > 0 {
+		switch {
+		case path[0] == '.':
+			path = path[1:]
+		case path[0] == '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, errors.New("Unclosed [ in expression")
+			}
+			key := path[1:end]
+			if unquoted, unquoteErr := strconv.Unquote(strings.Replace(key, "'", "\"", -1)); unquoteErr == nil {
+				key = unquoted
+			}
+			keys = append(keys, key)
+			path = path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			keys = append(keys, path[:end])
+			path = path[end:]
+		}
+	}
+	if len(keys) == 0 {
+		err = errors.New("Empty expression")
+	}
+	return
+}
+
+// lookupPath returns the value found at path inside data, a decoded JSON document
+func lookupPath(data interface{}, path string) (value interface{}, err error) {
+	keys, err := splitPath(path)
+	if err != nil {
+		return
+	}
+	value = data
+	for _, key := range keys {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var found bool
+			if value, found = v[key]; !found {
+				return nil, fmt.Errorf("Key %s not found", key)
+			}
+		case []interface{}:
+			index, convErr := strconv.Atoi(key)
+			if convErr != nil {
+				return nil, fmt.Errorf("Invalid array index %s", key)
+			}
+			if index < 0 {
+				index = len(v) + index
+			}
+			if index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("Array index %s out of range", key)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("Key %s not found", key)
+		}
+	}
+	return
+}
+
+// stringValue returns the string representation of a decoded JSON value
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		buffer := new(bytes.Buffer)
+		json.NewEncoder(buffer).Encode(v)
+		return strings.TrimSpace(buffer.String())
+	}
+}
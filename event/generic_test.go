@@ -0,0 +1,155 @@
+package event
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewGenericParser(t *testing.T) {
+	testCases := []struct {
+		options map[string]string
+		err     bool
+	}{
+		{map[string]string{"branch": "$.ref"}, false},
+		{map[string]string{"branch": "$.ref", "branch_regex": "^refs/heads/(.+)$", "commit": "$.after", "author": "$.sender.login"}, false},
+		{map[string]string{"branch": "ref", "repository": "repository.full_name", "repository_name": "repository.name"}, false},
+		{map[string]string{}, true},
+		{nil, true},
+		{map[string]string{"commit": "$.after"}, true},
+		{map[string]string{"branch": "$.ref", "unknown": "$.unknown"}, true},
+		{map[string]string{"branch": "$.ref", "commit_regex": ".*"}, true},
+		{map[string]string{"branch": "$.ref", "branch_regex": "(unclosed"}, true},
+		{map[string]string{"branch": "$.commits[0"}, true},
+		{map[string]string{"branch": "$"}, true},
+	}
+
+	for i, test := range testCases {
+		_, err := NewGenericParser(test.options)
+		if test.err && err == nil {
+			t.Errorf("%02d. NewGenericParser should fail with options %v", i, test.options)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. NewGenericParser should not fail with options %v, got %s", i, test.options, err)
+		}
+	}
+}
+
+func TestGenericEvent(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/github.com.json")
+
+	testCases := []struct {
+		options  map[string]string
+		expected RepoEvent
+		err      bool
+	}{
+		{
+			map[string]string{"branch": "$.ref", "commit": "$.after", "author": "$.sender.login"},
+			RepoEvent{Kind: KindPush, Branch: "refs/heads/master", Commit: "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", Author: "Wiston999"},
+			false,
+		},
+		{
+			map[string]string{"branch": "$.ref", "branch_regex": "^refs/heads/(.+)$", "commit": "$.commits[0].id"},
+			RepoEvent{Kind: KindPush, Branch: "master", Commit: "eddf11a4056b1abc8002c005ddc0a20cd5f1038a"},
+			false,
+		},
+		{
+			map[string]string{"branch": "ref", "branch_regex": "[a-z]+$", "commit": "commits.-1.id", "author": "$['head_commit']['author'][\"username\"]"},
+			RepoEvent{Kind: KindPush, Branch: "master", Commit: "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", Author: "Wiston999"},
+			false,
+		},
+		{
+			map[string]string{"branch": "$.ref", "repository": "$.repository.full_name", "repository_name": "$.repository.name"},
+			RepoEvent{Kind: KindPush, Branch: "refs/heads/master", Repository: Repository{Name: "hello-go", FullName: "Wiston999/hello-go"}},
+			false,
+		},
+		{
+			map[string]string{"branch": "$.ref", "kind": "$.repository.private", "kind_regex": "false"},
+			RepoEvent{Kind: "false", Branch: "refs/heads/master"},
+			false,
+		},
+		{
+			map[string]string{"branch": "$.repository.id"},
+			RepoEvent{Kind: KindPush, Branch: "115111233"},
+			false,
+		},
+		{
+			map[string]string{"branch": "$.ref", "branch_regex": "^refs/tags/(.+)$"},
+			RepoEvent{},
+			true,
+		},
+		{
+			map[string]string{"branch": "$.unknown"},
+			RepoEvent{},
+			true,
+		},
+		{
+			map[string]string{"branch": "$.commits[10].id"},
+			RepoEvent{},
+			true,
+		},
+		{
+			map[string]string{"branch": "$.ref.unknown"},
+			RepoEvent{},
+			true,
+		},
+		{
+			map[string]string{"branch": "$.base_ref"},
+			RepoEvent{},
+			true,
+		},
+	}
+
+	for i, test := range testCases {
+		parser, err := NewGenericParser(test.options)
+		if err != nil {
+			t.Fatalf("%02d. NewGenericParser should not fail with options %v, got %s", i, test.options, err)
+		}
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+		request.Header.Set("Content-Type", "application/json")
+
+		event, err := parser.Parse(request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. Generic parser should fail with options %v", i, test.options)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. Generic parser should not fail with options %v, got %s", i, test.options, err)
+			continue
+		}
+		if !reflect.DeepEqual(*event, test.expected) {
+			t.Errorf("%02d. Generic parser returned %#v, expected %#v", i, *event, test.expected)
+		}
+	}
+}
+
+func TestGenericEventKO(t *testing.T) {
+	unconfigured, _ := Lookup("generic")
+	request := httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+	_, err := unconfigured.Parse(request)
+	if err == nil {
+		t.Error("Generic parser should fail when it is not configured")
+	}
+
+	parser, _ := NewGenericParser(map[string]string{"branch": "$.ref"})
+	request = httptest.NewRequest("POST", "/test", nil)
+	_, err = parser.Parse(request)
+	if err == nil {
+		t.Error("Generic parser should fail with payload = nil")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(""))
+	_, err = parser.Parse(request)
+	if err == nil {
+		t.Error("Generic parser should fail with payload = \"\"")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+	_, err = parser.Parse(request)
+	if err == nil {
+		t.Error("Generic parser should fail with payload = \"{}\"")
+	}
+}
@@ -0,0 +1,132 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type giteaPayloadType struct {
+	Ref         string
+	Before      string
+	After       string
+	Pusher      giteaUser
+	Sender      giteaUser
+	Repository  giteaRepository
+	PullRequest githubPullRequest `json:"pull_request" yaml:"pull_request"`
+	Release     githubRelease
+	Commits     []jsonCommit
+}
+
+type giteaUser struct {
+	Login string
+}
+
+type giteaRepository struct {
+	Name     string
+	FullName string `json:"full_name" yaml:"full_name"`
+	HTMLURL  string `json:"html_url" yaml:"html_url"`
+	CloneURL string `json:"clone_url" yaml:"clone_url"`
+	SSHURL   string `json:"ssh_url" yaml:"ssh_url"`
+}
+
+// NewGiteaEvent takes an http.Request object and parses it corresponding
+// to Gitea webhook syntax into an RepoEvent object.
+// Gogs and Forgejo webhooks share the same syntax so they are parsed by this function too.
+// The event kind is read from X-Gitea-Event (X-Forgejo-Event or X-Gogs-Event) header, push
+// is assumed if it is not present
+// It returns a RepoEvent object and an error in case of error
+func NewGiteaEvent(request *http.Request) (event *RepoEvent, err error) {
+	if request.Body == nil {
+		err = errors.New("Unable to parse request.Body == nil")
+		return
+	}
+	var parsedPayload giteaPayloadType
+	var repoEvent RepoEvent
+	var kind, branch, author, commit string
+	err = json.NewDecoder(request.Body).Decode(&parsedPayload)
+
+	if err != nil {
+		return
+	}
+
+	var giteaEvent string
+	for _, header := range []string{"X-Gitea-Event", "X-Forgejo-Event", "X-Gogs-Event"} {
+		if giteaEvent = request.Header.Get(header); giteaEvent != "" {
+			break
+		}
+	}
+	switch giteaEvent {
+	case "", "push":
+		kind = KindPush
+		if strings.HasPrefix(parsedPayload.Ref, "refs/tags/") {
+			kind = KindTagPush
+		}
+		repoEvent.setRef(parsedPayload.Ref)
+		repoEvent.setSHAs(parsedPayload.Before, parsedPayload.After)
+		repoEvent.Pusher = parsedPayload.Pusher.Login
+		repoEvent.Commits = commits(parsedPayload.Commits)
+		branch = repoEvent.Branch
+		commit = parsedPayload.After
+		author = parsedPayload.Pusher.Login
+		if repoEvent.Deleted {
+			commit = parsedPayload.Before
+		}
+	case "pull_request":
+		kind = KindPullRequest
+		branch = parsedPayload.PullRequest.Head.Ref
+		commit = parsedPayload.PullRequest.Head.Sha
+		author = parsedPayload.Sender.Login
+	case "release":
+		kind = KindRelease
+		branch = parsedPayload.Release.TagName
+		// target_commitish is usually a branch name, the tagged commit is not sent
+		author = parsedPayload.Sender.Login
+		repoEvent.Tag = branch
+	default:
+		return nil, UnsupportedEventError{giteaEvent}
+	}
+
+	if branch == "" {
+		err = errors.New("Unable to parse branch")
+	}
+	if commit == "" && kind != KindRelease {
+		err = errors.New("Unable to parse commit")
+	}
+	if author == "" {
+		err = errors.New("Unable to parse author")
+	}
+	repoEvent.Kind = kind
+	repoEvent.Author = author
+	repoEvent.Branch = branch
+	repoEvent.Commit = commit
+	repoEvent.Repository = Repository{
+		Name:     parsedPayload.Repository.Name,
+		FullName: parsedPayload.Repository.FullName,
+		HTMLURL:  parsedPayload.Repository.HTMLURL,
+		CloneURL: parsedPayload.Repository.CloneURL,
+		SSHURL:   parsedPayload.Repository.SSHURL,
+	}
+	repoEvent.ChangedPaths = changedPaths(repoEvent.Commits)
+	event = &repoEvent
+	return
+}
+
+// ValidateGiteaSignature checks the HMAC signature of the request body sent by Gitea at
+// X-Gitea-Signature header. Forgejo (X-Forgejo-Signature) and Gogs (X-Gogs-Signature)
+// headers are also accepted. The signature is the plain hex encoded HMAC-SHA256 digest.
+// It returns an error if the signature is missing or does not match
+func ValidateGiteaSignature(request *http.Request, body []byte, secret string) (err error) {
+	var signature string
+	for _, header := range []string{"X-Gitea-Signature", "X-Forgejo-Signature", "X-Gogs-Signature"} {
+		if signature = request.Header.Get(header); signature != "" {
+			break
+		}
+	}
+	if signature == "" {
+		return MissingCredentialsError{"Signature not found in request"}
+	}
+	return compareHMAC(sha256.New, signature, body, secret)
+}
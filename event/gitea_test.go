@@ -0,0 +1,169 @@
+package event
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGiteaEventOK(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/gitea.json")
+	request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+	request.Header.Set("Content-Type", "application/json")
+
+	event, err := NewGiteaEvent(request)
+	if err != nil {
+		t.Error("NewGiteaEvent should not return err != nil")
+	}
+	if event.Author != "gitea" {
+		t.Error("event.Author must be gitea, got", event.Author)
+	}
+
+	if event.Branch != "develop" {
+		t.Error("event.Branch must be develop, got", event.Branch)
+	}
+
+	if event.Ref != "refs/heads/develop" || event.Before != "28e1879d029cb852e4844d9c718537df08844e03" || event.Pusher != "gitea" {
+		t.Errorf("event.Ref, event.Before and event.Pusher do not match the payload, got %s, %s and %s", event.Ref, event.Before, event.Pusher)
+	}
+
+	if event.Repository.CloneURL != "http://localhost:3000/gitea/webhooks.git" || event.Repository.SSHURL != "ssh://gitea@localhost:2222/gitea/webhooks.git" {
+		t.Errorf("event.Repository does not match the payload, got %#v", event.Repository)
+	}
+
+	if len(event.Commits) != 1 || event.Commits[0].Message != "Webhooks Yay!" || event.Commits[0].Email != "someone@gitea.io" {
+		t.Errorf("event.Commits does not match the payload, got %#v", event.Commits)
+	}
+
+	if event.Commit != "bffeb74224043ba2feb48d137756c8a9331c449a" {
+		t.Error("event.Commit must be bffeb74224043ba2feb48d137756c8a9331c449a, got", event.Commit)
+	}
+
+	if event.Repository.FullName != "gitea/webhooks" {
+		t.Error("event.Repository.FullName must be gitea/webhooks, got", event.Repository.FullName)
+	}
+}
+
+func TestGiteaEventKO(t *testing.T) {
+	request := httptest.NewRequest("POST", "/test", nil)
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err := NewGiteaEvent(request)
+	if err == nil {
+		t.Error("NewGiteaEvent should fail with payload = nil")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader(""))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewGiteaEvent(request)
+	if err == nil {
+		t.Error("NewGiteaEvent should fail with payload = \"\"")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewGiteaEvent(request)
+	if err == nil {
+		t.Error("NewGiteaEvent should fail with payload = \"{}\"")
+	}
+
+	request = httptest.NewRequest("POST", "/test", strings.NewReader("{\"ref\": \"refs/heads/master\", \"after\": \"0123456789abcdef\"}"))
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = NewGiteaEvent(request)
+	if err == nil {
+		t.Error("NewGiteaEvent should fail without pusher")
+	}
+}
+
+func TestGiteaEventKinds(t *testing.T) {
+	push, _ := ioutil.ReadFile("../payloads/gitea.json")
+	tagPush := strings.Replace(string(push), "refs/heads/develop", "refs/tags/v1.0.0", 1)
+	pullRequest := `{"action": "opened", "pull_request": {"head": {"ref": "feature/a", "sha": "ef8755f06ee4b28c96a847a95cb8ec8ed6ddd1ca"}}, "sender": {"login": "gitea"}}`
+	release := `{"action": "published", "release": {"tag_name": "v1.0.0", "target_commitish": "master"}, "sender": {"login": "gitea"}}`
+
+	testCases := []struct {
+		header  string
+		value   string
+		payload string
+		kind    string
+		branch  string
+		err     bool
+	}{
+		{"X-Gitea-Event", "", string(push), KindPush, "develop", false},
+		{"X-Gitea-Event", "push", string(push), KindPush, "develop", false},
+		{"X-Gogs-Event", "push", tagPush, KindTagPush, "v1.0.0", false},
+		{"X-Forgejo-Event", "pull_request", pullRequest, KindPullRequest, "feature/a", false},
+		{"X-Gitea-Event", "release", release, KindRelease, "v1.0.0", false},
+		{"X-Gitea-Event", "pull_request", string(push), "", "", true},
+		{"X-Gitea-Event", "issues", string(push), "", "", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(test.header, test.value)
+
+		event, err := NewGiteaEvent(request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. NewGiteaEvent should fail with %s %s", i, test.header, test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. NewGiteaEvent should not fail with %s %s, got %s", i, test.header, test.value, err)
+			continue
+		}
+		if test.kind == KindRelease && event.Commit != "" {
+			t.Errorf("%02d. NewGiteaEvent must not set the commit of releases, got %s", i, event.Commit)
+		}
+		if event.Kind != test.kind || event.Branch != test.branch {
+			t.Errorf("%02d. NewGiteaEvent returned %#v, expected kind %s and branch %s", i, event, test.kind, test.branch)
+		}
+	}
+}
+
+func TestValidateGiteaSignature(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/gitea.json")
+	secret := "my-gitea-secret"
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	testCases := []struct {
+		header    string
+		signature string
+		secret    string
+		err       bool
+	}{
+		{"X-Gitea-Signature", signature, secret, false},
+		{"X-Forgejo-Signature", signature, secret, false},
+		{"X-Gogs-Signature", signature, secret, false},
+		{"X-Gitea-Signature", signature, "another-secret", true},
+		{"X-Gitea-Signature", "sha256=" + signature, secret, true},
+		{"X-Gitea-Signature", "0123456789abcdef", secret, true},
+		{"X-Gitea-Signature", "", secret, true},
+		{"X-Hub-Signature", signature, secret, true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(test.header, test.signature)
+
+		err := ValidateGiteaSignature(request, payload, test.secret)
+		if test.err && err == nil {
+			t.Errorf("%02d. ValidateGiteaSignature should fail with %s: %s", i, test.header, test.signature)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. ValidateGiteaSignature should not fail with %s: %s, got %s", i, test.header, test.signature, err)
+		}
+	}
+}
@@ -9,21 +9,51 @@ import (
 )
 
 type githubPayloadType struct {
-	Ref        string
-	HeadCommit headCommit `json:"head_commit" yaml:"head_commit"`
+	Ref         string
+	Before      string
+	After       string
+	HeadCommit  jsonCommit        `json:"head_commit" yaml:"head_commit"`
+	PullRequest githubPullRequest `json:"pull_request" yaml:"pull_request"`
+	Release     githubRelease
+	Sender      githubUser
+	Pusher      githubPusher
+	Repository  githubRepository
+	Commits     []jsonCommit
 }
 
-type headCommit struct {
-	ID     string
-	Author commitAuthor
+type githubPusher struct {
+	Name string
 }
 
-type commitAuthor struct {
-	Username string
+type githubRepository struct {
+	Name     string
+	FullName string `json:"full_name" yaml:"full_name"`
+	HTMLURL  string `json:"html_url" yaml:"html_url"`
+	CloneURL string `json:"clone_url" yaml:"clone_url"`
+	SSHURL   string `json:"ssh_url" yaml:"ssh_url"`
+}
+
+type githubPullRequest struct {
+	Head githubPullRequestRef
+}
+
+type githubPullRequestRef struct {
+	Ref string
+	Sha string
+}
+
+type githubRelease struct {
+	TagName string `json:"tag_name" yaml:"tag_name"`
+	Author  githubUser
+}
+
+type githubUser struct {
+	Login string
 }
 
 // NewGithubEvent takes an http.Request object and parses it corresponding
 // to Github webhook syntax into an RepoEvent object.
+// The event kind is read from X-GitHub-Event header, push is assumed if it is not present
 // It returns a RepoEvent object and an error in case of error
 func NewGithubEvent(request *http.Request) (event *RepoEvent, err error) {
 	var payload []byte
@@ -44,26 +74,81 @@ func NewGithubEvent(request *http.Request) (event *RepoEvent, err error) {
 	}
 
 	var parsedPayload githubPayloadType
-	var branch, author, commit string
+	var repoEvent RepoEvent
+	var kind, branch, author, commit string
 	err = json.Unmarshal(payload, &parsedPayload)
 	if err != nil {
 		return nil, err
 	}
 
-	sl := strings.Split(parsedPayload.Ref, "/")
-	branch = sl[len(sl)-1]
-	commit = parsedPayload.HeadCommit.ID
-	author = parsedPayload.HeadCommit.Author.Username
+	switch githubEvent := request.Header.Get("X-GitHub-Event"); githubEvent {
+	case "ping":
+		return &RepoEvent{Kind: KindPing}, nil
+	case "", "push":
+		kind = KindPush
+		if strings.HasPrefix(parsedPayload.Ref, "refs/tags/") {
+			kind = KindTagPush
+		}
+		repoEvent.setRef(parsedPayload.Ref)
+		repoEvent.setSHAs(parsedPayload.Before, parsedPayload.After)
+		repoEvent.Pusher = parsedPayload.Pusher.Name
+		repoEvent.Commits = commits(parsedPayload.Commits)
+		branch = repoEvent.Branch
+		commit = parsedPayload.HeadCommit.ID
+		author = parsedPayload.HeadCommit.Author.Username
+		if repoEvent.Deleted {
+			commit = parsedPayload.Before
+		}
+		if author == "" {
+			author = repoEvent.Pusher
+		}
+	case "pull_request":
+		kind = KindPullRequest
+		branch = parsedPayload.PullRequest.Head.Ref
+		commit = parsedPayload.PullRequest.Head.Sha
+		author = parsedPayload.Sender.Login
+	case "release":
+		kind = KindRelease
+		branch = parsedPayload.Release.TagName
+		// target_commitish is usually a branch name, the tagged commit is not sent
+		author = parsedPayload.Release.Author.Login
+		repoEvent.Tag = branch
+	default:
+		return nil, UnsupportedEventError{githubEvent}
+	}
 
 	if branch == "" {
 		err = errors.New("Unable to parse branch")
 	}
-	if commit == "" {
+	if commit == "" && kind != KindRelease {
 		err = errors.New("Unable to parse commit")
 	}
 	if author == "" {
 		err = errors.New("Unable to parse author")
 	}
-	event = &RepoEvent{Author: author, Branch: branch, Commit: commit}
+	repoEvent.Kind = kind
+	repoEvent.Author = author
+	repoEvent.Branch = branch
+	repoEvent.Commit = commit
+	repoEvent.Repository = Repository{
+		Name:     parsedPayload.Repository.Name,
+		FullName: parsedPayload.Repository.FullName,
+		HTMLURL:  parsedPayload.Repository.HTMLURL,
+		CloneURL: parsedPayload.Repository.CloneURL,
+		SSHURL:   parsedPayload.Repository.SSHURL,
+	}
+	repoEvent.ChangedPaths = changedPaths(repoEvent.Commits)
+	event = &repoEvent
 	return
 }
+
+// ValidateGithubSignature checks the HMAC signature of the request body sent by Github.
+// X-Hub-Signature-256 header is preferred and X-Hub-Signature (SHA1) is used as fallback.
+// It returns an error if the signature is missing or does not match
+func ValidateGithubSignature(request *http.Request, body []byte, secret string) (err error) {
+	signature := request.Header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		signature = request.Header.Get("X-Hub-Signature")
+	}
+	return validateHMAC(signature, body, secret)
+}
@@ -1,6 +1,10 @@
 package event
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
 	"net/http/httptest"
 	"net/url"
@@ -29,6 +33,26 @@ func TestGithubEventOK(t *testing.T) {
 		t.Error("event.Commit must be eddf11a4056b1abc8002c005ddc0a20cd5f1038a, got", event.Author)
 	}
 
+	if strings.Join(event.ChangedPaths, ",") != "README.md" {
+		t.Error("event.ChangedPaths must be [README.md], got", event.ChangedPaths)
+	}
+
+	if event.Ref != "refs/heads/master" || event.Before != "2e8b7f0b9272b7374dadb6f13100a36bfe03eceb" || event.After != event.Commit {
+		t.Errorf("event.Ref, event.Before and event.After do not match the payload, got %s, %s and %s", event.Ref, event.Before, event.After)
+	}
+
+	if event.Pusher != "Wiston999" || event.Created || event.Deleted {
+		t.Errorf("event.Pusher must be Wiston999 and the branch neither created nor deleted, got %#v", event)
+	}
+
+	if event.Repository.FullName != "Wiston999/hello-go" || event.Repository.CloneURL != "https://github.com/Wiston999/hello-go.git" || event.Repository.SSHURL != "git@github.com:Wiston999/hello-go.git" {
+		t.Errorf("event.Repository does not match the payload, got %#v", event.Repository)
+	}
+
+	if len(event.Commits) != 1 || event.Commits[0].Message != "Update README.md" || event.Commits[0].Author != "Victor Cabezas" {
+		t.Errorf("event.Commits does not match the payload, got %#v", event.Commits)
+	}
+
 	v := url.Values{}
 	v.Add("payload", string(payload))
 	request = httptest.NewRequest("POST", "/test", strings.NewReader(v.Encode()))
@@ -128,3 +152,138 @@ func TestGithubEventKO(t *testing.T) {
 		t.Error("NewGithubEvent should fail with request method != POST")
 	}
 }
+
+func TestValidateGithubSignature(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/github.com.json")
+	secret := "It's a Secret to Everybody"
+
+	mac256 := hmac.New(sha256.New, []byte(secret))
+	mac256.Write(payload)
+	mac1 := hmac.New(sha1.New, []byte(secret))
+	mac1.Write(payload)
+
+	testCases := []struct {
+		header    string
+		signature string
+		secret    string
+		err       bool
+	}{
+		{"X-Hub-Signature-256", "sha256=" + hex.EncodeToString(mac256.Sum(nil)), secret, false},
+		{"X-Hub-Signature", "sha1=" + hex.EncodeToString(mac1.Sum(nil)), secret, false},
+		{"X-Hub-Signature-256", "sha256=" + hex.EncodeToString(mac256.Sum(nil)), "another secret", true},
+		{"X-Hub-Signature-256", "sha256=" + hex.EncodeToString(mac1.Sum(nil)), secret, true},
+		{"X-Hub-Signature-256", "sha512=" + hex.EncodeToString(mac256.Sum(nil)), secret, true},
+		{"X-Hub-Signature-256", "sha256=nothexencoded", secret, true},
+		{"X-Hub-Signature-256", hex.EncodeToString(mac256.Sum(nil)), secret, true},
+		{"X-Hub-Signature-256", "", secret, true},
+		{"X-Another-Header", "sha256=" + hex.EncodeToString(mac256.Sum(nil)), secret, true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(string(payload)))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(test.header, test.signature)
+
+		err := ValidateGithubSignature(request, payload, test.secret)
+		if test.err && err == nil {
+			t.Errorf("%02d. ValidateGithubSignature should fail with %s: %s", i, test.header, test.signature)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. ValidateGithubSignature should not fail with %s: %s, got %s", i, test.header, test.signature, err)
+		}
+		missing := test.signature == "" || test.header == "X-Another-Header"
+		if _, ok := err.(MissingCredentialsError); ok != missing {
+			t.Errorf("%02d. ValidateGithubSignature missing signature error should be %v, got %#v", i, missing, err)
+		}
+	}
+}
+
+func TestGithubEventKinds(t *testing.T) {
+	push, _ := ioutil.ReadFile("../payloads/github.com.json")
+	ping, _ := ioutil.ReadFile("../payloads/github.com-ping.json")
+	pullRequest, _ := ioutil.ReadFile("../payloads/github.com-pull_request.json")
+	tagPush := strings.Replace(string(push), "refs/heads/master", "refs/tags/v1.0.0", 1)
+	release := `{"action": "published", "release": {"tag_name": "v1.0.0", "target_commitish": "master", "author": {"login": "Wiston999"}}}`
+
+	testCases := []struct {
+		githubEvent string
+		payload     string
+		kind        string
+		branch      string
+		commit      string
+		err         bool
+	}{
+		{"", string(push), KindPush, "master", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false},
+		{"push", string(push), KindPush, "master", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false},
+		{"push", tagPush, KindTagPush, "v1.0.0", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false},
+		{"ping", string(ping), KindPing, "", "", false},
+		{"pull_request", string(pullRequest), KindPullRequest, "feature/spanish", "b2a1f9e6c3c5d36a2b6a9c93e0f0e1e1d9d3b4a7", false},
+		{"release", release, KindRelease, "v1.0.0", "", false},
+		{"pull_request", string(push), "", "", "", true},
+		{"push", string(ping), "", "", "", true},
+		{"issues", string(push), "", "", "", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-GitHub-Event", test.githubEvent)
+
+		event, err := NewGithubEvent(request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. NewGithubEvent should fail with X-GitHub-Event %s", i, test.githubEvent)
+			}
+			if _, unsupported := err.(UnsupportedEventError); unsupported != (test.githubEvent == "issues") {
+				t.Errorf("%02d. NewGithubEvent should only return UnsupportedEventError for unsupported events, got %#v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. NewGithubEvent should not fail with X-GitHub-Event %s, got %s", i, test.githubEvent, err)
+			continue
+		}
+		if event.Kind != test.kind || event.Branch != test.branch || event.Commit != test.commit {
+			t.Errorf("%02d. NewGithubEvent returned %#v, expected kind %s, branch %s and commit %s", i, event, test.kind, test.branch, test.commit)
+		}
+	}
+}
+
+func TestGithubEventRefs(t *testing.T) {
+	push, _ := ioutil.ReadFile("../payloads/github.com.json")
+	nestedBranch := strings.Replace(string(push), "refs/heads/master", "refs/heads/feature/foo", 1)
+	tagPush := strings.Replace(string(push), "refs/heads/master", "refs/tags/v1.0.0", 1)
+	deleted := `{"ref": "refs/heads/feature/foo", "before": "2e8b7f0b9272b7374dadb6f13100a36bfe03eceb",
+		"after": "0000000000000000000000000000000000000000", "deleted": true, "head_commit": null, "pusher": {"name": "Wiston999"}}`
+
+	testCases := []struct {
+		payload string
+		ref     string
+		branch  string
+		tag     string
+		commit  string
+		deleted bool
+	}{
+		{string(push), "refs/heads/master", "master", "", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false},
+		{nestedBranch, "refs/heads/feature/foo", "feature/foo", "", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false},
+		{tagPush, "refs/tags/v1.0.0", "v1.0.0", "v1.0.0", "eddf11a4056b1abc8002c005ddc0a20cd5f1038a", false},
+		{deleted, "refs/heads/feature/foo", "feature/foo", "", "2e8b7f0b9272b7374dadb6f13100a36bfe03eceb", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-GitHub-Event", "push")
+
+		event, err := NewGithubEvent(request)
+		if err != nil {
+			t.Errorf("%02d. NewGithubEvent should not fail, got %s", i, err)
+			continue
+		}
+		if event.Ref != test.ref || event.Branch != test.branch || event.Tag != test.tag || event.Commit != test.commit || event.Deleted != test.deleted {
+			t.Errorf("%02d. NewGithubEvent returned %#v, expected ref %s, branch %s, tag %s, commit %s and deleted %v", i, event, test.ref, test.branch, test.tag, test.commit, test.deleted)
+		}
+		if event.Author != "Wiston999" {
+			t.Errorf("%02d. event.Author must be Wiston999, got %s", i, event.Author)
+		}
+	}
+}
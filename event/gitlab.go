@@ -1,6 +1,7 @@
 package event
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -8,13 +9,48 @@ import (
 )
 
 type gitlabPayloadType struct {
-	Ref          string `json:"ref" yaml:"ref"`
-	UserUsername string `json:"user_username" yaml:"user_username"`
-	CheckoutSha  string `json:"checkout_sha" yaml:"checkout_sha"`
+	Ref              string                 `json:"ref" yaml:"ref"`
+	Before           string                 `json:"before" yaml:"before"`
+	After            string                 `json:"after" yaml:"after"`
+	UserUsername     string                 `json:"user_username" yaml:"user_username"`
+	CheckoutSha      string                 `json:"checkout_sha" yaml:"checkout_sha"`
+	User             gitlabUser             `json:"user" yaml:"user"`
+	ObjectAttributes gitlabObjectAttributes `json:"object_attributes" yaml:"object_attributes"`
+	Tag              string                 `json:"tag" yaml:"tag"`
+	Commit           gitlabCommit           `json:"commit" yaml:"commit"`
+	Project          gitlabProject          `json:"project" yaml:"project"`
+	Commits          []jsonCommit           `json:"commits" yaml:"commits"`
+}
+
+type gitlabProject struct {
+	Name              string `json:"name" yaml:"name"`
+	PathWithNamespace string `json:"path_with_namespace" yaml:"path_with_namespace"`
+	WebURL            string `json:"web_url" yaml:"web_url"`
+	GitHTTPURL        string `json:"git_http_url" yaml:"git_http_url"`
+	GitSSHURL         string `json:"git_ssh_url" yaml:"git_ssh_url"`
+}
+
+type gitlabUser struct {
+	Username string `json:"username" yaml:"username"`
+}
+
+type gitlabObjectAttributes struct {
+	SourceBranch string       `json:"source_branch" yaml:"source_branch"`
+	LastCommit   gitlabCommit `json:"last_commit" yaml:"last_commit"`
+}
+
+type gitlabCommit struct {
+	ID     string             `json:"id" yaml:"id"`
+	Author gitlabCommitAuthor `json:"author" yaml:"author"`
+}
+
+type gitlabCommitAuthor struct {
+	Name string `json:"name" yaml:"name"`
 }
 
 // NewGitlabEvent takes an http.Request object and parses it corresponding
 // to Gitlab webhook syntax into an RepoEvent object.
+// The event kind is read from X-Gitlab-Event header, push is assumed if it is not present
 // It returns a RepoEvent object and an error in case of error
 func NewGitlabEvent(request *http.Request) (event *RepoEvent, err error) {
 	if request.Body == nil {
@@ -22,17 +58,44 @@ func NewGitlabEvent(request *http.Request) (event *RepoEvent, err error) {
 		return
 	}
 	var parsedPayload gitlabPayloadType
-	var branch, author, commit string
+	var repoEvent RepoEvent
+	var kind, branch, author, commit string
 	err = json.NewDecoder(request.Body).Decode(&parsedPayload)
 
 	if err != nil {
 		return
 	}
 
-	sl := strings.Split(parsedPayload.Ref, "/")
-	branch = sl[len(sl)-1]
-	commit = parsedPayload.CheckoutSha
-	author = parsedPayload.UserUsername
+	switch gitlabEvent := request.Header.Get("X-Gitlab-Event"); gitlabEvent {
+	case "", "Push Hook", "Tag Push Hook":
+		kind = KindPush
+		if gitlabEvent == "Tag Push Hook" || strings.HasPrefix(parsedPayload.Ref, "refs/tags/") {
+			kind = KindTagPush
+		}
+		repoEvent.setRef(parsedPayload.Ref)
+		repoEvent.setSHAs(parsedPayload.Before, parsedPayload.After)
+		repoEvent.Pusher = parsedPayload.UserUsername
+		repoEvent.Commits = commits(parsedPayload.Commits)
+		branch = repoEvent.Branch
+		commit = parsedPayload.CheckoutSha
+		author = parsedPayload.UserUsername
+		if repoEvent.Deleted {
+			commit = parsedPayload.Before
+		}
+	case "Merge Request Hook":
+		kind = KindMergeRequest
+		branch = parsedPayload.ObjectAttributes.SourceBranch
+		commit = parsedPayload.ObjectAttributes.LastCommit.ID
+		author = parsedPayload.User.Username
+	case "Release Hook":
+		kind = KindRelease
+		branch = parsedPayload.Tag
+		commit = parsedPayload.Commit.ID
+		author = parsedPayload.Commit.Author.Name
+		repoEvent.Tag = branch
+	default:
+		return nil, UnsupportedEventError{gitlabEvent}
+	}
 
 	if branch == "" {
 		err = errors.New("Unable to parse branch")
@@ -43,6 +106,32 @@ func NewGitlabEvent(request *http.Request) (event *RepoEvent, err error) {
 	if author == "" {
 		err = errors.New("Unable to parse author")
 	}
-	event = &RepoEvent{Author: author, Branch: branch, Commit: commit}
+	repoEvent.Kind = kind
+	repoEvent.Author = author
+	repoEvent.Branch = branch
+	repoEvent.Commit = commit
+	repoEvent.Repository = Repository{
+		Name:     parsedPayload.Project.Name,
+		FullName: parsedPayload.Project.PathWithNamespace,
+		HTMLURL:  parsedPayload.Project.WebURL,
+		CloneURL: parsedPayload.Project.GitHTTPURL,
+		SSHURL:   parsedPayload.Project.GitSSHURL,
+	}
+	repoEvent.ChangedPaths = changedPaths(repoEvent.Commits)
+	event = &repoEvent
+	return
+}
+
+// ValidateGitlabToken checks the secret token sent by Gitlab at X-Gitlab-Token header.
+// The comparison is done in constant time
+// It returns an error if the token is missing or does not match
+func ValidateGitlabToken(request *http.Request, body []byte, secret string) (err error) {
+	token := request.Header.Get("X-Gitlab-Token")
+	if token == "" {
+		return MissingCredentialsError{"Token not found in request"}
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return errors.New("Token does not match")
+	}
 	return
 }
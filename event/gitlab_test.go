@@ -27,6 +27,22 @@ func TestGitlabEventOK(t *testing.T) {
 	if event.Commit != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" {
 		t.Error("event.Commit must be da1560886d4f094c3e6c9ef40349f7d38b5d27d7, got", event.Author)
 	}
+
+	if strings.Join(event.ChangedPaths, ",") != "CHANGELOG,app/controller/application.rb" {
+		t.Error("event.ChangedPaths must be [CHANGELOG app/controller/application.rb], got", event.ChangedPaths)
+	}
+
+	if event.Ref != "refs/heads/master" || event.Before != "95790bf891e76fee5e1747ab589903a6a1f80f22" || event.After != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" {
+		t.Errorf("event.Ref, event.Before and event.After do not match the payload, got %s, %s and %s", event.Ref, event.Before, event.After)
+	}
+
+	if event.Repository.FullName != "mike/diaspora" || event.Repository.CloneURL != "http://example.com/mike/diaspora.git" || event.Repository.SSHURL != "git@example.com:mike/diaspora.git" {
+		t.Errorf("event.Repository does not match the payload, got %#v", event.Repository)
+	}
+
+	if len(event.Commits) != 2 || event.Commits[0].Author != "Jordi Mallach" || event.Commits[0].Message != "Update Catalan translation to e38cb41." {
+		t.Errorf("event.Commits does not match the payload, got %#v", event.Commits)
+	}
 }
 
 func TestGitlabEventKO(t *testing.T) {
@@ -78,3 +94,76 @@ func TestGitlabEventKO(t *testing.T) {
 		t.Error("NewGithubEvent should fail request method != POST")
 	}
 }
+
+func TestValidateGitlabToken(t *testing.T) {
+	testCases := []struct {
+		token  string
+		secret string
+		err    bool
+	}{
+		{"my-secret-token", "my-secret-token", false},
+		{"my-secret-token", "another-secret-token", true},
+		{"my-secret", "my-secret-token", true},
+		{"", "my-secret-token", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+		request.Header.Set("Content-Type", "application/json")
+		if test.token != "" {
+			request.Header.Set("X-Gitlab-Token", test.token)
+		}
+
+		err := ValidateGitlabToken(request, []byte("{}"), test.secret)
+		if test.err && err == nil {
+			t.Errorf("%02d. ValidateGitlabToken should fail with token %s", i, test.token)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. ValidateGitlabToken should not fail with token %s, got %s", i, test.token, err)
+		}
+	}
+}
+
+func TestGitlabEventKinds(t *testing.T) {
+	push, _ := ioutil.ReadFile("../payloads/gitlab.com.json")
+	mergeRequest, _ := ioutil.ReadFile("../payloads/gitlab.com-merge_request.json")
+	tagPush := strings.Replace(string(push), "refs/heads/master", "refs/tags/v1.0.0", 1)
+	release := `{"object_kind": "release", "tag": "v1.0.0", "commit": {"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "author": {"name": "John Smith"}}}`
+
+	testCases := []struct {
+		gitlabEvent string
+		payload     string
+		kind        string
+		branch      string
+		commit      string
+		err         bool
+	}{
+		{"", string(push), KindPush, "master", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", false},
+		{"Push Hook", string(push), KindPush, "master", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", false},
+		{"Tag Push Hook", tagPush, KindTagPush, "v1.0.0", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", false},
+		{"Merge Request Hook", string(mergeRequest), KindMergeRequest, "ms-viewport", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", false},
+		{"Release Hook", release, KindRelease, "v1.0.0", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", false},
+		{"Merge Request Hook", string(push), "", "", "", true},
+		{"Issue Hook", string(push), "", "", "", true},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Gitlab-Event", test.gitlabEvent)
+
+		event, err := NewGitlabEvent(request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. NewGitlabEvent should fail with X-Gitlab-Event %s", i, test.gitlabEvent)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. NewGitlabEvent should not fail with X-Gitlab-Event %s, got %s", i, test.gitlabEvent, err)
+			continue
+		}
+		if event.Kind != test.kind || event.Branch != test.branch || event.Commit != test.commit {
+			t.Errorf("%02d. NewGitlabEvent returned %#v, expected kind %s, branch %s and commit %s", i, event, test.kind, test.branch, test.commit)
+		}
+	}
+}
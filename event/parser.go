@@ -0,0 +1,108 @@
+package event
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Parser is the interface that must be implemented by repository provider's hook parsers
+type Parser interface {
+	// Parse parses an http.Request into a RepoEvent
+	Parse(request *http.Request) (event *RepoEvent, err error)
+}
+
+// Validator is the interface that parsers able to validate the authenticity of the requests
+// must implement. body is the raw request body and secret is the hook configured secret
+type Validator interface {
+	// Validate returns an error if the request cannot be authenticated using secret
+	Validate(request *http.Request, body []byte, secret string) (err error)
+}
+
+// MissingCredentialsError is returned by the validators when the request does not include the signature,
+// token or credentials to check, as opposed to including ones which do not match
+type MissingCredentialsError struct {
+	Msg string
+}
+
+// Error implements error
+func (e MissingCredentialsError) Error() string {
+	return e.Msg
+}
+
+// Configurable is the interface that parsers needing per hook settings must implement.
+// Configure receives the hook options and returns the Parser that will be used by the hook
+type Configurable interface {
+	// Configure returns a Parser configured using options or error if options are not valid
+	Configure(options map[string]string) (parser Parser, err error)
+}
+
+// ParserFunc is an adapter to allow the use of ordinary functions as Parser
+type ParserFunc func(request *http.Request) (event *RepoEvent, err error)
+
+// Parse calls f(request)
+func (f ParserFunc) Parse(request *http.Request) (event *RepoEvent, err error) {
+	return f(request)
+}
+
+// validatingParser joins a parsing and a validation function into a Parser that is also a Validator
+type validatingParser struct {
+	ParserFunc
+	validate func(request *http.Request, body []byte, secret string) error
+}
+
+// Validate calls the underlying validation function
+func (p validatingParser) Validate(request *http.Request, body []byte, secret string) (err error) {
+	return p.validate(request, body, secret)
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = make(map[string]Parser)
+)
+
+func init() {
+	Register("azure-devops", validatingParser{NewAzureDevopsEvent, ValidateAzureDevopsBasicAuth})
+	Register("bitbucket", validatingParser{NewBitbucketEvent, ValidateBitbucketSignature})
+	Register("bitbucket-server", validatingParser{NewBitbucketServerEvent, ValidateBitbucketSignature})
+	Register("github", validatingParser{NewGithubEvent, ValidateGithubSignature})
+	Register("gitlab", validatingParser{NewGitlabEvent, ValidateGitlabToken})
+	Register("generic", genericParser{})
+	Register("gitea", validatingParser{NewGiteaEvent, ValidateGiteaSignature})
+	Register("gogs", validatingParser{NewGiteaEvent, ValidateGiteaSignature})
+	Register("forgejo", validatingParser{NewGiteaEvent, ValidateGiteaSignature})
+}
+
+// Register makes a Parser available for the hooks of type name
+// If Register is called twice with the same name or if parser is nil, it panics
+func Register(name string, parser Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	if parser == nil {
+		panic("event: Register parser is nil")
+	}
+	if _, dup := parsers[name]; dup {
+		panic("event: Register called twice for parser " + name)
+	}
+	parsers[name] = parser
+}
+
+// Lookup returns the Parser registered for the hooks of type name
+// found is false if there is no Parser registered with that name
+func Lookup(name string) (parser Parser, found bool) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	parser, found = parsers[name]
+	return
+}
+
+// Parsers returns a sorted list of the names of the registered parsers
+func Parsers() (names []string) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,80 @@
+package event
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinParsers(t *testing.T) {
+	for _, name := range []string{"azure-devops", "bitbucket", "bitbucket-server", "github", "gitlab", "gitea", "gogs", "forgejo"} {
+		parser, found := Lookup(name)
+		if !found {
+			t.Errorf("Parser %s should be registered by default", name)
+			continue
+		}
+		if _, ok := parser.(Validator); !ok {
+			t.Errorf("Parser %s should implement Validator", name)
+		}
+	}
+
+	if _, found := Lookup("unknown"); found {
+		t.Errorf("Lookup should not find unregistered parsers")
+	}
+}
+
+// unregister removes the parser registered with name, so tests can register it again
+func unregister(name string) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	delete(parsers, name)
+}
+
+func TestRegister(t *testing.T) {
+	parser := ParserFunc(func(request *http.Request) (*RepoEvent, error) {
+		return &RepoEvent{Author: "author", Branch: "branch", Commit: "commit"}, nil
+	})
+	Register("TestRegister", parser)
+	defer unregister("TestRegister")
+
+	registered, found := Lookup("TestRegister")
+	if !found {
+		t.Fatalf("Lookup should find registered parser")
+	}
+	event, err := registered.Parse(httptest.NewRequest("POST", "/test", strings.NewReader("{}")))
+	if err != nil || event.Branch != "branch" {
+		t.Errorf("Registered parser should be returned by Lookup, got %v and %v", event, err)
+	}
+	if _, ok := registered.(Validator); ok {
+		t.Errorf("ParserFunc should not implement Validator")
+	}
+
+	names := Parsers()
+	if !strings.Contains(strings.Join(names, ","), "TestRegister") {
+		t.Errorf("Parsers should contain registered parser names, got %v", names)
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] > names[i] {
+			t.Errorf("Parsers should return a sorted list, got %v", names)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		parser Parser
+	}{
+		{"TestRegister", parser},
+		{"TestRegisterNil", nil},
+	}
+	for i, test := range testCases {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%02d. Register should panic registering %s", i, test.name)
+				}
+			}()
+			Register(test.name, test.parser)
+		}()
+	}
+}
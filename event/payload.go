@@ -0,0 +1,54 @@
+package event
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sensitiveHeaders are never exposed to the hook commands as they carry
+// credentials or request signatures
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"X-Forgejo-Signature",
+	"X-Gitea-Signature",
+	"X-Gitlab-Token",
+	"X-Gogs-Signature",
+	"X-Hub-Signature",
+	"X-Hub-Signature-256",
+}
+
+// SetRequest fills Payload and Headers using the request which originated the event
+// and its raw body. Payload is left empty if the body is not a JSON document (or a form
+// holding a JSON document at its payload field, as sent by Github).
+// Headers holds a copy of the request headers without the ones carrying credentials
+func (e *RepoEvent) SetRequest(request *http.Request, body []byte) {
+	e.Payload = decodePayload(request.Header.Get("Content-Type"), body)
+	e.Headers = http.Header{}
+	for key, values := range request.Header {
+		e.Headers[key] = append([]string(nil), values...)
+	}
+	for _, key := range sensitiveHeaders {
+		e.Headers.Del(key)
+	}
+}
+
+// decodePayload decodes the request body into a generic map, contentType is used to detect form
+// encoded bodies, in which case the JSON document is read from the payload field.
+// It returns nil if the body cannot be decoded
+func decodePayload(contentType string, body []byte) (payload map[string]interface{}) {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil
+		}
+		body = []byte(values.Get("payload"))
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package event
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSetRequest(t *testing.T) {
+	payload, _ := ioutil.ReadFile("../payloads/github.com.json")
+	form := url.Values{}
+	form.Add("payload", string(payload))
+
+	testCases := []struct {
+		contentType string
+		body        string
+		fullName    interface{}
+	}{
+		{"application/json", string(payload), "Wiston999/hello-go"},
+		{"application/json; charset=utf-8", string(payload), "Wiston999/hello-go"},
+		{"application/x-www-form-urlencoded", form.Encode(), "Wiston999/hello-go"},
+		{"application/json", "not a json document", nil},
+		{"application/json", "", nil},
+	}
+
+	for i, test := range testCases {
+		request := httptest.NewRequest("POST", "/test", strings.NewReader(test.body))
+		request.Header.Set("Content-Type", test.contentType)
+		request.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		request.Header.Set("X-Hub-Signature", "sha1=7d38cdd689735b008b3c702edd92eea23791c5f6")
+		request.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+
+		var event RepoEvent
+		event.SetRequest(request, []byte(test.body))
+		if test.fullName == nil {
+			if event.Payload != nil {
+				t.Errorf("%02d. SetRequest should not decode payload %q, got %v", i, test.body, event.Payload)
+			}
+		} else {
+			repository, _ := event.Payload["repository"].(map[string]interface{})
+			if repository["full_name"] != test.fullName {
+				t.Errorf("%02d. Payload repository.full_name must be %v, got %v", i, test.fullName, repository["full_name"])
+			}
+		}
+		if event.Headers.Get("X-GitHub-Delivery") != "72d3162e-cc78-11e3-81ab-4c9367dc0958" {
+			t.Errorf("%02d. Headers must contain X-GitHub-Delivery, got %v", i, event.Headers)
+		}
+		if event.Headers.Get("X-Hub-Signature") != "" || event.Headers.Get("Authorization") != "" {
+			t.Errorf("%02d. Headers must not contain credentials, got %v", i, event.Headers)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package event
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+)
+
+// validateHMAC checks that signature is the hex encoded HMAC of body using secret as key
+// signature must be prefixed by the hash algorithm used, i.e.: sha256=<hex digest> or sha1=<hex digest>
+// The comparison is done in constant time
+// It returns an error if the signature is not valid
+func validateHMAC(signature string, body []byte, secret string) (err error) {
+	if signature == "" {
+		return MissingCredentialsError{"Signature not found in request"}
+	}
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("Malformed signature, expected <algorithm>=<digest>")
+	}
+
+	var hashFunc func() hash.Hash
+	switch parts[0] {
+	case "sha256":
+		hashFunc = sha256.New
+	case "sha1":
+		hashFunc = sha1.New
+	default:
+		return errors.New("Unsupported signature algorithm " + parts[0])
+	}
+
+	return compareHMAC(hashFunc, parts[1], body, secret)
+}
+
+// compareHMAC computes the HMAC of body using hashFunc and secret and compares it
+// against the hex encoded digest in constant time
+func compareHMAC(hashFunc func() hash.Hash, digest string, body []byte, secret string) (err error) {
+	received, err := hex.DecodeString(digest)
+	if err != nil {
+		return errors.New("Malformed signature, digest is not hex encoded")
+	}
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return errors.New("Signature does not match")
+	}
+	return
+}
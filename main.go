@@ -77,12 +77,13 @@ func main() {
 	}
 
 	server := server.Server{
-		Server:      &http.Server{Addr: fmt.Sprintf("%s:%d", opts.Addr, opts.Port)},
-		TLSCert:     opts.TLSCert,
-		TLSKey:      opts.TLSKey,
-		CmdLogDir:   opts.LogDir,
-		CmdLogLimit: opts.LogLimit,
-		Hooks:       hooks,
+		Server:            &http.Server{Addr: fmt.Sprintf("%s:%d", opts.Addr, opts.Port)},
+		TLSCert:           opts.TLSCert,
+		TLSKey:            opts.TLSKey,
+		CmdLogDir:         opts.LogDir,
+		CmdLogLimit:       opts.LogLimit,
+		WorkerChannelSize: opts.WorkQueueSize,
+		Hooks:             hooks,
 	}
 	log.WithFields(log.Fields{"addr": opts.Addr, "port": opts.Port}).Debug("Starting web server")
 	log.Fatal(server.ListenAndServe())
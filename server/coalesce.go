@@ -0,0 +1,75 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescer tracks the latest job of every coalesce key of a hook and the jobs waiting for their
+// debounce delay, see Hook.Coalesce and Hook.Debounce
+// It is safe for concurrent use
+type coalescer struct {
+	mutex   sync.Mutex
+	latest  map[string]string
+	waiting map[string]*debouncedJob
+}
+
+// debouncedJob is a job waiting for its debounce delay before being dispatched
+type debouncedJob struct {
+	job   CommandJob
+	timer *time.Timer
+}
+
+// newCoalescer creates an empty coalescer
+func newCoalescer() *coalescer {
+	return &coalescer{latest: make(map[string]string), waiting: make(map[string]*debouncedJob)}
+}
+
+// latestJob returns the id of the latest job for key, empty if there is none
+func (c *coalescer) latestJob(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.latest[key]
+}
+
+// forget removes key once its latest job, id, is finished so finished keys are not kept
+// It does nothing if a newer job replaced id
+func (c *coalescer) forget(key, id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.latest[key] == id {
+		delete(c.latest, key)
+	}
+}
+
+// replace sets the job id as the latest one for key
+// It returns the id of the previous latest job for key, empty if there is none
+func (c *coalescer) replace(key, id string) (previous string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	previous = c.latest[key]
+	c.latest[key] = id
+	return
+}
+
+// debounce calls dispatch with job once delay passes without a newer job for key, replacing the job
+// waiting for key, if any
+// It returns the replaced job, which is not dispatched, or nil if no job was waiting for key
+func (c *coalescer) debounce(key string, job CommandJob, delay time.Duration, dispatch func(CommandJob)) (previous *CommandJob) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if waiting, found := c.waiting[key]; found && waiting.timer.Stop() {
+		previous = &waiting.job
+	}
+	next := &debouncedJob{job: job}
+	next.timer = time.AfterFunc(delay, func() {
+		c.mutex.Lock()
+		if c.waiting[key] == next {
+			delete(c.waiting, key)
+		}
+		c.mutex.Unlock()
+		dispatch(job)
+	})
+	c.waiting[key] = next
+	return
+}
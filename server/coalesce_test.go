@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	c := newCoalescer()
+	testCases := []struct {
+		key      string
+		id       string
+		previous string
+	}{
+		{"master", "job-1", ""},
+		{"develop", "job-2", ""},
+		{"master", "job-3", "job-1"},
+		{"master", "job-4", "job-3"},
+		{"develop", "job-5", "job-2"},
+	}
+	for i, test := range testCases {
+		if previous := c.replace(test.key, test.id); previous != test.previous {
+			t.Errorf("%02d. replace returned %q, expected %q", i, previous, test.previous)
+		}
+	}
+
+	c.forget("master", "job-3")
+	c.forget("develop", "job-5")
+	if latest := c.latestJob("master"); latest != "job-4" {
+		t.Errorf("Superseded jobs must not remove the latest job of their key, got %q", latest)
+	}
+	if _, found := c.latest["develop"]; found {
+		t.Error("Finished latest job must remove its key")
+	}
+
+	dispatched := make(chan CommandJob, 3)
+	dispatch := func(job CommandJob) { dispatched <- job }
+	if previous := c.debounce("master", CommandJob{ID: "job-1"}, 50*time.Millisecond, dispatch); previous != nil {
+		t.Errorf("First debounced job must not replace any job, got %#v", previous)
+	}
+	if previous := c.debounce("develop", CommandJob{ID: "job-2"}, 50*time.Millisecond, dispatch); previous != nil {
+		t.Errorf("Debounced jobs with different keys must not replace each other, got %#v", previous)
+	}
+	if previous := c.debounce("master", CommandJob{ID: "job-3"}, 50*time.Millisecond, dispatch); previous == nil || previous.ID != "job-1" {
+		t.Errorf("Debounced job must replace the waiting job with the same key, got %#v", previous)
+	}
+
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case job := <-dispatched:
+			ids[job.ID] = true
+		case <-time.After(time.Second):
+			t.Fatal("Debounced jobs must be dispatched after the delay")
+		}
+	}
+	if !ids["job-2"] || !ids["job-3"] {
+		t.Errorf("Last debounced job of every key must be dispatched, got %v", ids)
+	}
+	if previous := c.debounce("master", CommandJob{ID: "job-4"}, 50*time.Millisecond, dispatch); previous != nil {
+		t.Errorf("Dispatched jobs must not be replaced, got %#v", previous)
+	}
+	<-dispatched
+}
@@ -2,84 +2,347 @@ package server
 
 import (
 	"bytes"
-	"context"
-	"errors"
-	"io/ioutil"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
-	"text/template"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Wiston999/githook/event"
 )
 
 // CommandResult stores the result of a command execution
+// ID is the job (request) identifier, Hook the hook name and Event the event which triggered the command
+// Err holds the error description, it is empty when the command succeeds. ExitCode is -1 when the
+// command could not be run or it was killed by a signal, in which case Signal holds its name.
+// TimedOut is set when the command was killed because its timeout expired and Cancelled when the job was
+// cancelled before running or stopped while running, Superseded holds the ID of the newer job that cancelled it
+// QueuedAt, StartedAt and FinishedAt hold the times when the job was queued, and the command started and
+// finished, Duration holds the execution time in seconds
+// Truncated is set when only the beginning and the end of Stdout or Stderr were stored, the whole
+// output is written to OutputFile when the hook defines an output directory
+// Rejected deliveries (requests failing validation) are also stored as a CommandResult
+// without command but with the rejection reason at Rejected and the client address at Remote, as well
+// as debounced jobs which could not be queued once their delay passed
+// Multi-step jobs store each step result, named by Step, at Steps
 type CommandResult struct {
-	Cmd    []string `json:"cmd"`
-	Err    error    `json:"err"`
-	Stdout []byte   `json:"stdout"`
-	Stderr []byte   `json:"stderr"`
+	ID         string           `json:"id,omitempty"`
+	Hook       string           `json:"hook,omitempty"`
+	Event      *event.RepoEvent `json:"event,omitempty"`
+	Cmd        []string         `json:"cmd"`
+	Err        string           `json:"err"`
+	ExitCode   int              `json:"exit_code"`
+	Signal     string           `json:"signal,omitempty"`
+	TimedOut   bool             `json:"timed_out,omitempty"`
+	Cancelled  bool             `json:"cancelled,omitempty"`
+	Superseded string           `json:"superseded,omitempty"`
+	QueuedAt   time.Time        `json:"queued_at"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Duration   float64          `json:"duration"`
+	Stdout     []byte           `json:"stdout"`
+	Stderr     []byte           `json:"stderr"`
+	Truncated  bool             `json:"truncated,omitempty"`
+	OutputFile string           `json:"output_file,omitempty"`
+	Remote     string           `json:"remote,omitempty"`
+	Rejected   string           `json:"rejected,omitempty"`
+	Step       string           `json:"step,omitempty"`
+	Steps      []CommandResult  `json:"steps,omitempty"`
+}
+
+// unknownError is stored as Err for results logged by older versions, which
+// encoded the error as an empty object so its description was lost
+const unknownError = "Unknown error"
+
+// UnmarshalJSON implements json.Unmarshaler, it accepts err encoded as a string, null
+// or an object (as stored by older versions) so existing command logs can still be read
+func (c *CommandResult) UnmarshalJSON(data []byte) (err error) {
+	type plainResult CommandResult
+	var result struct {
+		*plainResult
+		Err json.RawMessage `json:"err"`
+	}
+	result.plainResult = (*plainResult)(c)
+	if err = json.Unmarshal(data, &result); err != nil {
+		return
+	}
+
+	c.Err = ""
+	raw := bytes.TrimSpace(result.Err)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '"':
+		err = json.Unmarshal(raw, &c.Err)
+	default:
+		c.Err = unknownError
+	}
+	return
 }
 
 // TranslateParams translates a list of command parameters (from Hook) based
 // on the event received at event.RepoEvent. It uses Go's built-in templating (text/template)
-// so all operations on templates can be performed on the command parameters.
+// so all operations on templates can be performed on the command parameters, the functions defined
+// at templateFuncs are available too.
 // I.e.: `cmd := ["{{.Branch}}", "is", "the", "branch"]` with event.Branch := "develop" will
 // be transformed to `cmd := ["develop", "is", "the", "branch"]`
 // It returns the translated array of strings and error in case of error
 func TranslateParams(cmd []string, event event.RepoEvent) (trCmd []string, err error) {
-	for _, arg := range cmd {
-		tpl, tmpErr := template.New("cmd-template").Parse(arg)
+	templates, err := parseTemplates(cmd)
+	if err != nil {
+		return
+	}
+	for _, tpl := range templates {
+		buffer := new(bytes.Buffer)
+
+		tmpErr := tpl.Execute(buffer, event)
 		if tmpErr != nil {
 			err = tmpErr
 			return
 		}
-		buffer := new(bytes.Buffer)
+		trCmd = append(trCmd, buffer.String())
+	}
+	return
+}
 
-		tmpErr = tpl.Execute(buffer, event)
+// TranslateEnv translates the hook environment variables values based on the event received
+// at event.RepoEvent, the same way TranslateParams does
+// It returns the translated variables, sorted by name, with the form NAME=value and error in case of error
+func TranslateEnv(env map[string]string, event event.RepoEvent) (trEnv []string, err error) {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, tmpErr := TranslateParams([]string{env[name]}, event)
 		if tmpErr != nil {
-			err = tmpErr
+			err = fmt.Errorf("Unable to translate environment variable %s: %s", name, tmpErr)
 			return
 		}
-		trCmd = append(trCmd, buffer.String())
+		trEnv = append(trEnv, name+"="+value[0])
+	}
+	return
+}
+
+// validateEnv checks the hook environment variables names and value templates
+// It returns error if any name is empty or contains = or any value is not a valid template
+func validateEnv(env map[string]string) (err error) {
+	for name, value := range env {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("Invalid environment variable name %q", name)
+		}
+		if _, err = parseTemplates([]string{value}); err != nil {
+			return fmt.Errorf("Invalid environment variable %s template: %s", name, err)
+		}
 	}
 	return
 }
 
+// EventEnv returns the GITHOOK_* environment variables describing the event received
+// by the hook hookName at the request requestID
+func EventEnv(hookName, requestID string, event event.RepoEvent) []string {
+	return []string{
+		"GITHOOK_HOOK=" + hookName,
+		"GITHOOK_REQUEST_ID=" + requestID,
+		"GITHOOK_KIND=" + event.Kind,
+		"GITHOOK_REF=" + event.Ref,
+		"GITHOOK_BRANCH=" + event.Branch,
+		"GITHOOK_TAG=" + event.Tag,
+		"GITHOOK_COMMIT=" + event.Commit,
+		"GITHOOK_BEFORE=" + event.Before,
+		"GITHOOK_AFTER=" + event.After,
+		"GITHOOK_AUTHOR=" + event.Author,
+		"GITHOOK_PUSHER=" + event.Pusher,
+		"GITHOOK_REPOSITORY=" + event.Repository.FullName,
+		"GITHOOK_REPOSITORY_NAME=" + event.Repository.Name,
+		"GITHOOK_CLONE_URL=" + event.Repository.CloneURL,
+	}
+}
+
+// CommandOptions holds the optional settings of a command execution
+// Env holds the environment variables, with the form NAME=value, added to the githook environment
+// Stdin holds the data written to the command standard input, nothing is written if it is nil
+// Dir is the command working directory, it is created if CreateDir is set
+// User and Group (names or numeric ids) are used to run the command as a different user, which
+// requires githook to run as root, and Umask (octal string) is the command file mode creation mask, set by
+// running the command through /bin/sh.
+// User, Group and Umask are not supported on Windows
+// KillGrace is the number of seconds to wait, once the command timeout expires and it has been asked to
+// terminate, before killing it, 0 kills the command right away. The command process group is killed but
+// descendants which left it are not, their output is only read for outputWaitDelay once the command exits
+// MaxOutput is the number of bytes of stdout and stderr stored at the CommandResult, when the command writes
+// more only their beginning and end are stored, 0 stores the whole output. The whole output of both
+// streams is appended to OutputFile when it is set, and written to Output as it is produced when it is not nil
+// Closing Cancel stops the command the same way timeout does
+type CommandOptions struct {
+	Env        []string
+	Stdin      []byte
+	Dir        string
+	CreateDir  bool
+	User       string
+	Group      string
+	Umask      string
+	KillGrace  int
+	MaxOutput  int
+	OutputFile string
+	Output     io.Writer
+	Cancel     <-chan struct{}
+}
+
+// defaultKillGrace is the number of seconds hook commands are given to terminate before being killed
+const defaultKillGrace = 5
+
+// Reasons why watchCommand stops a command
+const (
+	stopTimeout = "timeout"
+	stopCancel  = "cancel"
+)
+
+// parseUmask parses an octal file mode creation mask, i.e.: 022 or 0027
+// It returns error if umask is not a valid octal number between 0 and 0777
+func parseUmask(umask string) (mask int, err error) {
+	parsed, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, fmt.Errorf("Invalid umask %q, it must be an octal number between 0 and 0777", umask)
+	}
+	return int(parsed), nil
+}
+
 // RunCommand executes the hook command on the system, it takes an array of string
 // representing the command to be returned, a timeout in seconds and a channel for returning the data.
 // It returns an instance of CommandResult
 func RunCommand(cmd []string, timeout int) (result CommandResult) {
+	return RunCommandWithOptions(cmd, timeout, CommandOptions{})
+}
+
+// RunCommandWithOptions executes the hook command on the system like RunCommand does,
+// using the environment variables and standard input given at options
+// The command runs in its own process group, when timeout expires the whole group is asked to terminate
+// (SIGTERM) and it is killed (SIGKILL) if it is still running after options.KillGrace seconds, so
+// processes started by the command do not survive it. On Windows only the command process is killed
+// The command is stopped the same way when options.Cancel is closed
+// It returns an instance of CommandResult
+func RunCommandWithOptions(cmd []string, timeout int, options CommandOptions) (result CommandResult) {
 	result.Cmd = cmd
+	result.ExitCode = -1
+	result.StartedAt = time.Now()
+	defer func() {
+		result.FinishedAt = time.Now()
+		result.Duration = result.FinishedAt.Sub(result.StartedAt).Seconds()
+	}()
 	if len(cmd) == 0 {
-		result.Err = errors.New("Empty command string cannot be run")
+		result.Err = "Empty command string cannot be run"
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-
-	command := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	stderr, err := command.StderrPipe()
-	if err != nil {
-		result.Err = err
-		return
+	command := exec.Command(cmd[0], cmd[1:]...)
+	if len(options.Env) > 0 {
+		command.Env = append(os.Environ(), options.Env...)
 	}
-
-	stdout, err := command.StdoutPipe()
+	if options.Stdin != nil {
+		command.Stdin = bytes.NewReader(options.Stdin)
+	}
+	if options.Dir != "" {
+		if options.CreateDir {
+			if err := os.MkdirAll(options.Dir, 0755); err != nil {
+				result.Err = err.Error()
+				return
+			}
+		}
+		command.Dir = options.Dir
+	}
+	stdout, stderr := newOutputBuffer(options.MaxOutput), newOutputBuffer(options.MaxOutput)
+	var outputs []io.Writer
+	if options.OutputFile != "" {
+		outputFile, err := os.OpenFile(options.OutputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			result.Err = err.Error()
+			return
+		}
+		defer outputFile.Close()
+		outputs = append(outputs, outputFile)
+		result.OutputFile = options.OutputFile
+	}
+	if options.Output != nil {
+		outputs = append(outputs, options.Output)
+	}
+	pipes, err := newOutputPipes(command,
+		io.MultiWriter(append([]io.Writer{stdout}, outputs...)...),
+		io.MultiWriter(append([]io.Writer{stderr}, outputs...)...))
 	if err != nil {
-		result.Err = err
+		result.Err = err.Error()
 		return
 	}
 
-	if err := command.Start(); err != nil {
-		result.Err = err
+	err = startCommand(command, options)
+	pipes.closeWriters()
+	if err != nil {
+		pipes.wait(0)
+		result.Err = err.Error()
 		return
 	}
 
-	result.Stdout, _ = ioutil.ReadAll(stdout)
-	result.Stderr, _ = ioutil.ReadAll(stderr)
+	done := make(chan struct{})
+	stopped := make(chan string, 1)
+	go watchCommand(command, timeout, options.KillGrace, options.Cancel, done, stopped)
 
-	if err := command.Wait(); err != nil {
-		result.Err = err
+	err = command.Wait()
+	close(done)
+	pipes.wait(outputWaitDelay)
+	result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+	result.Truncated = stdout.Truncated() || stderr.Truncated()
+	switch <-stopped {
+	case stopTimeout:
+		result.TimedOut = true
+	case stopCancel:
+		result.Cancelled = true
+	}
+	if command.ProcessState != nil {
+		if status, ok := command.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+			if status.Signaled() {
+				result.Signal = status.Signal().String()
+			}
+		}
+	}
+	if result.TimedOut {
+		result.Err = fmt.Sprintf("Command timed out after %d seconds", timeout)
+	} else if result.Cancelled {
+		result.Err = "Command cancelled"
+	} else if err != nil {
+		result.Err = err.Error()
 	}
 	return
 }
+
+// watchCommand terminates command when timeout seconds elapse or cancel is closed before done is closed,
+// and kills it if it is still running killGrace seconds later. The reason why command was stopped, empty
+// if it was not, is sent to stopped
+func watchCommand(command *exec.Cmd, timeout, killGrace int, cancel, done <-chan struct{}, stopped chan<- string) {
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-done:
+		stopped <- ""
+		return
+	case <-timer.C:
+		stopped <- stopTimeout
+	case <-cancel:
+		stopped <- stopCancel
+	}
+	if killGrace > 0 {
+		terminateCommand(command)
+		grace := time.NewTimer(time.Duration(killGrace) * time.Second)
+		defer grace.Stop()
+		select {
+		case <-done:
+			return
+		case <-grace.C:
+		}
+	}
+	killCommand(command)
+}
@@ -9,16 +9,22 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // CommandLog is the interface that must be implemented by command loggers
+// Implementations must be safe for concurrent use, results are appended by the workers, the hook
+// handlers and the debounce timers
 type CommandLog interface {
 	// AppendResult appends a CommandResult to the underlying CommandLog storage
 	AppendResult(result CommandResult) (deleted int, err error)
 	// GetResults returns at most the latest n CommandResult stored in the underlying storage
 	// sorted from latest to older, if n < 0 it returns all the CommandResult stored
 	GetResults(n int) (results []CommandResult, err error)
+	// GetResult returns the latest CommandResult of the job id stored in the underlying storage, rejected
+	// deliveries are not returned. The result is nil if it is not found
+	GetResult(id string) (result *CommandResult, err error)
 	// RotateResults rotates the older CommandResult stored in the underlying storage so
 	// only MaxCommands CommandResult are left in the underlying storage, it returns the number rotated results
 	// i.e.: the deleted ones
@@ -28,7 +34,9 @@ type CommandLog interface {
 }
 
 // MemoryCommandLog implements the CommandLog interface storing the results in memory
+// It is safe for concurrent use
 type MemoryCommandLog struct {
+	mutex       sync.RWMutex
 	MaxCommands int
 	CommandLog  []CommandResult
 }
@@ -40,15 +48,19 @@ func NewMemoryCommandLog(rotate int) *MemoryCommandLog {
 
 // AppendResult of MemoryCommandLog
 func (m *MemoryCommandLog) AppendResult(result CommandResult) (deleted int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.CommandLog = append(m.CommandLog, result)
 	if m.MaxCommands > 0 {
-		return m.RotateResults()
+		return m.rotate()
 	}
 	return 0, nil
 }
 
 // GetResults of MemoryCommandLog
 func (m *MemoryCommandLog) GetResults(n int) (results []CommandResult, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	if n < 0 {
 		results = make([]CommandResult, len(m.CommandLog))
 	} else {
@@ -62,8 +74,28 @@ func (m *MemoryCommandLog) GetResults(n int) (results []CommandResult, err error
 	return
 }
 
+// GetResult of MemoryCommandLog
+func (m *MemoryCommandLog) GetResult(id string) (result *CommandResult, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for i := len(m.CommandLog) - 1; i >= 0; i-- {
+		if m.CommandLog[i].ID == id && m.CommandLog[i].Rejected == "" {
+			cmdResult := m.CommandLog[i]
+			return &cmdResult, nil
+		}
+	}
+	return
+}
+
 // RotateResults of MemoryCommandLog
 func (m *MemoryCommandLog) RotateResults() (deleted int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.rotate()
+}
+
+// rotate implements RotateResults, the log must be locked
+func (m *MemoryCommandLog) rotate() (deleted int, err error) {
 	n := m.MaxCommands
 	if n < 0 {
 		return n, errors.New("Rotate value must be greater than 0")
@@ -79,13 +111,21 @@ func (m *MemoryCommandLog) RotateResults() (deleted int, err error) {
 
 // Count of MemoryCommandLog
 func (m *MemoryCommandLog) Count() (count int, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return len(m.CommandLog), nil
 }
 
 // DiskCommandLog implements the CommandLog interface storing the results in disk
+// It is safe for concurrent use by a single DiskCommandLog per Location
+// The results are indexed by job ID on the first GetResult call, index holds the file of the latest
+// result of every job and files the job ID of every indexed file
 type DiskCommandLog struct {
+	mutex       sync.RWMutex
 	Location    string
 	MaxCommands int
+	index       map[string]int
+	files       map[int]string
 }
 
 // NewDiskCommandLog creates and object of type DiskCommandLog
@@ -95,8 +135,11 @@ func NewDiskCommandLog(location string, rotate int) *DiskCommandLog {
 
 // AppendResult of DiskCommandLog
 func (d *DiskCommandLog) AppendResult(result CommandResult) (deleted int, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-	fileName, err := filepath.Abs(filepath.Join(d.Location, fmt.Sprintf("%d", time.Now().UnixNano())))
+	name := int(time.Now().UnixNano())
+	fileName, err := filepath.Abs(filepath.Join(d.Location, fmt.Sprintf("%d", name)))
 	if err != nil {
 		return
 	}
@@ -105,80 +148,147 @@ func (d *DiskCommandLog) AppendResult(result CommandResult) (deleted int, err er
 	if err != nil {
 		return
 	}
+	defer f.Close()
 
 	err = json.NewEncoder(f).Encode(result)
+	if err == nil && d.index != nil && result.Rejected == "" {
+		d.indexResult(name, result.ID)
+	}
 
 	if err == nil && d.MaxCommands > 0 {
-		return d.RotateResults()
+		return d.rotate()
 	}
 	return 0, err
 }
 
 // GetResults of DiskCommandLog
 func (d *DiskCommandLog) GetResults(n int) (results []CommandResult, err error) {
-	files, err := filepath.Glob(filepath.Join(d.Location, "*"))
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	filesInt, err := d.fileNames()
 	if err != nil {
 		return
 	}
-
-	var filesInt []int
-	for _, f := range files {
-		sf := strings.Split(f, "/")
-		sfi, _ := strconv.Atoi(sf[len(sf)-1])
-		filesInt = append(filesInt, sfi)
-	}
 	sort.Sort(sort.Reverse(sort.IntSlice(filesInt)))
 
 	if n < 0 {
-		n = len(files)
+		n = len(filesInt)
 	}
 	for _, fileName := range filesInt[:n] {
-		filePath, localErr := filepath.Abs(filepath.Join(d.Location, fmt.Sprintf("%d", fileName)))
-		if localErr != nil {
-			return results, localErr
-		}
-		file, localErr := os.Open(filePath)
+		cmdResult, localErr := d.readResult(fileName)
 		if localErr != nil {
 			return results, localErr
 		}
+		results = append(results, cmdResult)
+	}
+	return
+}
 
-		var cmdResult CommandResult
-		err = json.NewDecoder(file).Decode(&cmdResult)
-		if err != nil {
+// GetResult of DiskCommandLog
+func (d *DiskCommandLog) GetResult(id string) (result *CommandResult, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.index == nil {
+		if err = d.buildIndex(); err != nil {
 			return
 		}
-		results = append(results, cmdResult)
+	}
+	fileName, found := d.index[id]
+	if !found {
+		return
+	}
+	cmdResult, err := d.readResult(fileName)
+	if err != nil {
+		return
+	}
+	return &cmdResult, nil
+}
+
+// buildIndex indexes the stored results by job ID, the log must be locked
+func (d *DiskCommandLog) buildIndex() (err error) {
+	filesInt, err := d.fileNames()
+	if err != nil {
+		return
+	}
+	sort.Ints(filesInt)
+	d.index, d.files = make(map[string]int), make(map[int]string)
+	for _, fileName := range filesInt {
+		cmdResult, readErr := d.readResult(fileName)
+		if readErr != nil {
+			d.index, d.files = nil, nil
+			return readErr
+		}
+		if cmdResult.Rejected == "" {
+			d.indexResult(fileName, cmdResult.ID)
+		}
 	}
 	return
 }
 
+// indexResult sets the file fileName as the latest result of the job id, the log must be locked
+func (d *DiskCommandLog) indexResult(fileName int, id string) {
+	if previous, found := d.index[id]; found {
+		delete(d.files, previous)
+	}
+	d.index[id], d.files[fileName] = fileName, id
+}
+
+// fileNames returns the names of the stored result files, which are numbers
+func (d *DiskCommandLog) fileNames() (filesInt []int, err error) {
+	files, err := filepath.Glob(filepath.Join(d.Location, "*"))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		sf := strings.Split(f, "/")
+		sfi, _ := strconv.Atoi(sf[len(sf)-1])
+		filesInt = append(filesInt, sfi)
+	}
+	return
+}
+
+// readResult decodes the result stored at the file fileName
+func (d *DiskCommandLog) readResult(fileName int) (result CommandResult, err error) {
+	filePath, err := filepath.Abs(filepath.Join(d.Location, fmt.Sprintf("%d", fileName)))
+	if err != nil {
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&result)
+	return
+}
+
 // RotateResults of DiskCommandLog
 func (d *DiskCommandLog) RotateResults() (deleted int, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.rotate()
+}
+
+// rotate implements RotateResults, the log must be locked
+func (d *DiskCommandLog) rotate() (deleted int, err error) {
 	n := d.MaxCommands
 	if n < 0 {
 		return n, errors.New("Rotate value must be greater than 0")
-	} else if c, _ := d.Count(); c < n {
+	} else if c, _ := d.count(); c < n {
 		// Nothing to rotate
 		return 0, nil
 	}
-	c, err := d.Count()
+	c, err := d.count()
 	if err != nil {
 		return
 	}
 
 	n = c - n
 
-	files, err := filepath.Glob(filepath.Join(d.Location, "*"))
+	filesInt, err := d.fileNames()
 	if err != nil {
 		return
 	}
-
-	var filesInt []int
-	for _, f := range files {
-		sf := strings.Split(f, "/")
-		sfi, _ := strconv.Atoi(sf[len(sf)-1])
-		filesInt = append(filesInt, sfi)
-	}
 	sort.Ints(filesInt)
 
 	for _, fileName := range filesInt[:n] {
@@ -191,6 +301,12 @@ func (d *DiskCommandLog) RotateResults() (deleted int, err error) {
 		if err != nil {
 			return
 		}
+		if id, found := d.files[fileName]; found {
+			delete(d.files, fileName)
+			if d.index[id] == fileName {
+				delete(d.index, id)
+			}
+		}
 		deleted++
 	}
 	return
@@ -198,6 +314,13 @@ func (d *DiskCommandLog) RotateResults() (deleted int, err error) {
 
 // Count of DiskCommandLog
 func (d *DiskCommandLog) Count() (count int, err error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.count()
+}
+
+// count implements Count, the log must be locked
+func (d *DiskCommandLog) count() (count int, err error) {
 	files, err := filepath.Glob(filepath.Join(d.Location, "*"))
 	if err != nil {
 		return
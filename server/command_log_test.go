@@ -2,11 +2,16 @@ package server
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/Wiston999/githook/event"
 )
 
 func TestAppendResult(t *testing.T) {
@@ -322,3 +327,148 @@ func TestCount(t *testing.T) {
 		t.Errorf("[DiskCommandLog] Count should return %d and error should be nil, got %d %v", testRounds, c, err)
 	}
 }
+
+func TestResultsPersistence(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	expected := CommandResult{
+		ID:         "request-id",
+		Hook:       "my-hook",
+		Event:      &event.RepoEvent{Kind: event.KindPush, Branch: "master", Commit: "0123456789abcdef", Repository: event.Repository{FullName: "owner/repo"}},
+		Cmd:        []string{"false"},
+		Err:        "exit status 1",
+		ExitCode:   1,
+		QueuedAt:   now,
+		StartedAt:  now.Add(time.Second),
+		FinishedAt: now.Add(3 * time.Second),
+		Duration:   2,
+		Stdout:     []byte("stdout"),
+		Stderr:     []byte("stderr"),
+	}
+
+	cmdLogs := map[string]CommandLog{
+		"MemoryCommandLog": NewMemoryCommandLog(10),
+		"DiskCommandLog":   NewDiskCommandLog(tmpDir, 10),
+	}
+	for name, cmdLog := range cmdLogs {
+		if _, err := cmdLog.AppendResult(expected); err != nil {
+			t.Fatalf("[%s] AppendResult should not fail, got %s", name, err)
+		}
+		results, err := cmdLog.GetResults(1)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("[%s] GetResults should return 1 result, got %d %v", name, len(results), err)
+		}
+		if !reflect.DeepEqual(results[0], expected) {
+			t.Errorf("[%s] Stored result should be %#v, got %#v", name, expected, results[0])
+		}
+	}
+}
+
+func TestGetResultsPreviousFormat(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(tmpDir)
+
+	// Older versions stored err as an (empty) object and had no exit code nor timestamps
+	records := map[string]string{
+		"1": `{"cmd":["true"],"err":null,"stdout":"b2sK","stderr":null}`,
+		"2": `{"cmd":["false"],"err":{},"stdout":null,"stderr":null}`,
+	}
+	for name, record := range records {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(record+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := NewDiskCommandLog(tmpDir, 10).GetResults(-1)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("GetResults should read previous format records, got %d %v", len(results), err)
+	}
+	if results[0].Err != unknownError || !reflect.DeepEqual(results[0].Cmd, []string{"false"}) {
+		t.Errorf("Failed command should keep an error, got %#v", results[0])
+	}
+	if results[1].Err != "" || string(results[1].Stdout) != "ok\n" {
+		t.Errorf("Successful command should not have error, got %#v", results[1])
+	}
+}
+
+func TestConcurrentResults(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(tmpDir)
+
+	cmdLogs := map[string]CommandLog{
+		"MemoryCommandLog": NewMemoryCommandLog(5),
+		"DiskCommandLog":   NewDiskCommandLog(tmpDir, 5),
+	}
+	for name, cmdLog := range cmdLogs {
+		done := make(chan bool)
+		for i := 0; i < 4; i++ {
+			go func(i int) {
+				for j := 0; j < 10; j++ {
+					cmdLog.AppendResult(CommandResult{ID: fmt.Sprintf("job-%d-%d", i, j)})
+				}
+				done <- true
+			}(i)
+			go func() {
+				for j := 0; j < 10; j++ {
+					if _, err := cmdLog.GetResults(-1); err != nil {
+						t.Errorf("[%s] GetResults should not fail while results are appended, got %s", name, err)
+					}
+				}
+				done <- true
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			<-done
+		}
+		if count, _ := cmdLog.Count(); count != 5 {
+			t.Errorf("[%s] Log should hold 5 results, got %d", name, count)
+		}
+	}
+}
+
+func TestGetResult(t *testing.T) {
+	tmpDir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(tmpDir)
+
+	// The disk log is indexed from the stored results too
+	NewDiskCommandLog(tmpDir, 3).AppendResult(CommandResult{ID: "job-0"})
+	cmdLogs := map[string]CommandLog{
+		"MemoryCommandLog": NewMemoryCommandLog(3),
+		"DiskCommandLog":   NewDiskCommandLog(tmpDir, 3),
+	}
+	for name, cmdLog := range cmdLogs {
+		if name == "MemoryCommandLog" {
+			cmdLog.AppendResult(CommandResult{ID: "job-0"})
+		}
+		if result, err := cmdLog.GetResult("job-0"); err != nil || result == nil {
+			t.Errorf("[%s] GetResult should find job-0, got %v %v", name, result, err)
+		}
+		cmdLog.AppendResult(CommandResult{ID: "job-1", Err: "first"})
+		cmdLog.AppendResult(CommandResult{ID: "job-1", Err: "latest"})
+		cmdLog.AppendResult(CommandResult{ID: "job-2", Rejected: "Invalid signature"})
+
+		// job-0 is rotated and job-2 is a rejected delivery
+		testCases := []struct {
+			id    string
+			found bool
+		}{
+			{"job-0", false},
+			{"job-1", true},
+			{"job-2", false},
+			{"unknown", false},
+		}
+		for i, test := range testCases {
+			result, err := cmdLog.GetResult(test.id)
+			if err != nil {
+				t.Errorf("[%s] %02d. GetResult should not fail, got %s", name, i, err)
+			}
+			if !test.found && result != nil {
+				t.Errorf("[%s] %02d. GetResult should not find %s, got %#v", name, i, test.id, result)
+			} else if test.found && (result == nil || result.Err != "latest") {
+				t.Errorf("[%s] %02d. GetResult should return the latest result of %s, got %#v", name, i, test.id, result)
+			}
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -9,7 +12,16 @@ import (
 )
 
 func TestTranslateParams(t *testing.T) {
-	event := event.RepoEvent{Branch: "my-branch", Author: "my-self", Commit: "0123456789abcdef"}
+	event := event.RepoEvent{
+		Branch:     "my-branch",
+		Author:     "my-self",
+		Commit:     "0123456789abcdef",
+		Ref:        "refs/heads/my-branch",
+		Before:     "fedcba9876543210",
+		After:      "0123456789abcdef",
+		Repository: event.Repository{CloneURL: "https://example.com/my-repo.git"},
+		Commits:    []event.Commit{{ID: "0123456789abcdef", Message: "My commit"}},
+	}
 
 	testCases := []struct {
 		tCase    []string
@@ -56,6 +68,16 @@ func TestTranslateParams(t *testing.T) {
 			[]string{"0123456789abcdef", "my-branch", "my-self"},
 			false,
 		},
+		{
+			[]string{"{{.Ref}}", "{{.Repository.CloneURL}}", "{{range .Commits}}{{.ID}}:{{.Message}} {{end}}"},
+			[]string{"refs/heads/my-branch", "https://example.com/my-repo.git", "0123456789abcdef:My commit "},
+			false,
+		},
+		{
+			[]string{"{{if .Deleted}}deleted{{else}}{{.Before}}..{{.After}}{{end}}"},
+			[]string{"fedcba9876543210..0123456789abcdef"},
+			false,
+		},
 		{
 			[]string{"{{.Unknown}}"},
 			[]string{},
@@ -142,7 +164,7 @@ func TestRunCommand(t *testing.T) {
 	for i, test := range testCases {
 		got := RunCommand(test.cmd, test.timeout)
 
-		if got.Err != nil && !test.expectedErr {
+		if got.Err != "" && !test.expectedErr {
 			t.Errorf("%02d. RunCommand should not throw error with %v but got %s", i, test.cmd, got.Err)
 		}
 		if match, _ := regexp.Match(test.expectedStdout, got.Stdout); !match {
@@ -153,3 +175,185 @@ func TestRunCommand(t *testing.T) {
 		}
 	}
 }
+
+func TestTranslateEnv(t *testing.T) {
+	repoEvent := event.RepoEvent{Branch: "feature/foo", Commit: "0123456789abcdef"}
+
+	testCases := []struct {
+		env      map[string]string
+		expected []string
+		err      bool
+	}{
+		{nil, nil, false},
+		{map[string]string{"STATIC": "value"}, []string{"STATIC=value"}, false},
+		{
+			map[string]string{"TAG": "{{.Branch | slugify}}-{{.Commit | shortSha}}", "BRANCH": "{{.Branch}}"},
+			[]string{"BRANCH=feature/foo", "TAG=feature-foo-0123456"},
+			false,
+		},
+		{map[string]string{"BROKEN": "{{.Unknown}}"}, nil, true},
+	}
+
+	for i, test := range testCases {
+		got, err := TranslateEnv(test.env, repoEvent)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. TranslateEnv should fail with %v", i, test.env)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. TranslateEnv should not fail with %v, got %s", i, test.env, err)
+		}
+		if strings.Join(got, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%02d. TranslateEnv returned %v, expected %v", i, got, test.expected)
+		}
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	testCases := []struct {
+		env map[string]string
+		err bool
+	}{
+		{nil, false},
+		{map[string]string{"TAG": "{{.Branch | slugify}}"}, false},
+		{map[string]string{"": "value"}, true},
+		{map[string]string{"A=B": "value"}, true},
+		{map[string]string{"TAG": "{{.Branch"}, true},
+	}
+
+	for i, test := range testCases {
+		err := validateEnv(test.env)
+		if test.err && err == nil {
+			t.Errorf("%02d. validateEnv should fail with %v", i, test.env)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. validateEnv should not fail with %v, got %s", i, test.env, err)
+		}
+	}
+}
+
+func TestEventEnv(t *testing.T) {
+	repoEvent := event.RepoEvent{Kind: event.KindPush, Branch: "master", Commit: "0123456789abcdef", Author: "my-self"}
+	env := strings.Join(EventEnv("my-hook", "my-request-id", repoEvent), "\n") + "\n"
+	for _, expected := range []string{
+		"GITHOOK_HOOK=my-hook\n",
+		"GITHOOK_REQUEST_ID=my-request-id\n",
+		"GITHOOK_KIND=push\n",
+		"GITHOOK_BRANCH=master\n",
+		"GITHOOK_COMMIT=0123456789abcdef\n",
+		"GITHOOK_AUTHOR=my-self\n",
+	} {
+		if !strings.Contains(env, expected) {
+			t.Errorf("EventEnv must contain %s, got %s", expected, env)
+		}
+	}
+}
+
+func TestRunCommandWithOptions(t *testing.T) {
+	os.Setenv("GITHOOK_INHERITED_TEST", "inherited")
+	defer os.Unsetenv("GITHOOK_INHERITED_TEST")
+	workdir, err := ioutil.TempDir("", "githook-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+	workdir, _ = filepath.EvalSymlinks(workdir)
+
+	testCases := []struct {
+		cmd            []string
+		options        CommandOptions
+		expectedStdout string
+	}{
+		{
+			[]string{"sh", "-c", "echo -n $GITHOOK_BRANCH"},
+			CommandOptions{Env: []string{"GITHOOK_BRANCH=master"}},
+			"^master$",
+		},
+		{
+			[]string{"sh", "-c", "echo -n $GITHOOK_INHERITED_TEST"},
+			CommandOptions{Env: []string{"GITHOOK_BRANCH=master"}},
+			"^inherited$",
+		},
+		{
+			[]string{"cat"},
+			CommandOptions{Stdin: []byte(`{"ref": "refs/heads/master"}`)},
+			`^\{"ref": "refs/heads/master"\}$`,
+		},
+		{
+			[]string{"cat"},
+			CommandOptions{},
+			"^$",
+		},
+		{
+			[]string{"pwd"},
+			CommandOptions{Dir: workdir},
+			"^" + regexp.QuoteMeta(workdir) + "\n$",
+		},
+		{
+			[]string{"pwd"},
+			CommandOptions{Dir: filepath.Join(workdir, "feature", "foo"), CreateDir: true},
+			"^" + regexp.QuoteMeta(filepath.Join(workdir, "feature", "foo")) + "\n$",
+		},
+	}
+
+	for i, test := range testCases {
+		got := RunCommandWithOptions(test.cmd, 10, test.options)
+
+		if got.Err != "" {
+			t.Errorf("%02d. RunCommandWithOptions should not throw error with %v but got %s", i, test.cmd, got.Err)
+		}
+		if match, _ := regexp.Match(test.expectedStdout, got.Stdout); !match {
+			t.Errorf("%02d. RunCommandWithOptions STDOUT does not match, expected %v but got %s", i, test.expectedStdout, got.Stdout)
+		}
+	}
+}
+
+func TestRunCommandWithOptionsKO(t *testing.T) {
+	testCases := []struct {
+		cmd     []string
+		options CommandOptions
+	}{
+		{[]string{"pwd"}, CommandOptions{Dir: "/this/directory/does/not/exist"}},
+		{[]string{"pwd"}, CommandOptions{Dir: "/dev/null/cannot-create", CreateDir: true}},
+		{[]string{"pwd"}, CommandOptions{Umask: "999"}},
+		{[]string{"pwd"}, CommandOptions{User: "this-user-does-not-exist"}},
+	}
+
+	for i, test := range testCases {
+		got := RunCommandWithOptions(test.cmd, 10, test.options)
+		if got.Err == "" {
+			t.Errorf("%02d. RunCommandWithOptions should fail with %#v", i, test.options)
+		}
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	testCases := []struct {
+		umask    string
+		expected int
+		err      bool
+	}{
+		{"022", 022, false},
+		{"0027", 027, false},
+		{"0", 0, false},
+		{"777", 0777, false},
+		{"1777", 0, true},
+		{"089", 0, true},
+		{"", 0, true},
+		{"u=rwx", 0, true},
+	}
+
+	for i, test := range testCases {
+		got, err := parseUmask(test.umask)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. parseUmask should fail with %s", i, test.umask)
+			}
+			continue
+		}
+		if err != nil || got != test.expected {
+			t.Errorf("%02d. parseUmask(%s) returned %o (%v), expected %o", i, test.umask, got, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// startCommand starts command in a new process group running it as options.User and options.Group
+// with options.Umask
+// It returns error if the settings cannot be applied or the command cannot be started
+func startCommand(command *exec.Cmd, options CommandOptions) (err error) {
+	credential, err := lookupCredential(options.User, options.Group)
+	if err != nil {
+		return
+	}
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
+	if options.Umask != "" {
+		mask, maskErr := parseUmask(options.Umask)
+		if maskErr != nil {
+			return maskErr
+		}
+		if err = umaskCommand(command, mask); err != nil {
+			return
+		}
+	}
+	return command.Start()
+}
+
+// umaskCommand wraps command with a shell setting mask as its file mode creation mask before running it,
+// as the umask is a process wide setting which cannot be changed for githook while other files are created
+// It returns error if the command is not found
+func umaskCommand(command *exec.Cmd, mask int) (err error) {
+	path := command.Path
+	if filepath.Base(path) == path {
+		// exec.Command resolves commands without separators, it is left as is when not found
+		if path, err = exec.LookPath(path); err != nil {
+			return
+		}
+	}
+	script := fmt.Sprintf(`umask %04o && exec "$@"`, mask)
+	command.Args = append([]string{scriptShell, "-c", script, "githook", path}, command.Args[1:]...)
+	command.Path = scriptShell
+	return
+}
+
+// terminateCommand sends SIGTERM to the command process group
+func terminateCommand(command *exec.Cmd) {
+	syscall.Kill(-command.Process.Pid, syscall.SIGTERM)
+}
+
+// killCommand sends SIGKILL to the command process group
+func killCommand(command *exec.Cmd) {
+	syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+}
+
+// validateProcessOptions checks that userName, groupName and umask can be applied to the commands
+// It returns error if the user or group do not exist, githook is not allowed to switch to them
+// or umask is not a valid octal mask
+func validateProcessOptions(userName, groupName, umask string) (err error) {
+	if umask != "" {
+		if _, err = parseUmask(umask); err != nil {
+			return
+		}
+	}
+	credential, err := lookupCredential(userName, groupName)
+	if err != nil {
+		return
+	}
+	if credential != nil && os.Geteuid() != 0 {
+		return errors.New("Running commands as a different user or group requires githook to run as root")
+	}
+	return
+}
+
+// lookupCredential resolves userName and groupName, which can be names or numeric ids, into a
+// syscall.Credential. When groupName is empty the user primary group is used.
+// It returns nil if no user nor group are given or they match the current ones
+func lookupCredential(userName, groupName string) (credential *syscall.Credential, err error) {
+	if userName == "" && groupName == "" {
+		return
+	}
+	uid, gid := os.Geteuid(), os.Getegid()
+	if userName != "" {
+		if uid, gid, err = lookupUser(userName); err != nil {
+			return
+		}
+	}
+	if groupName != "" {
+		if gid, err = lookupGroup(groupName); err != nil {
+			return
+		}
+	}
+	if uid == os.Geteuid() && gid == os.Getegid() {
+		return
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+// lookupUser returns the uid and primary gid of userName, numeric ids unknown to the
+// system are accepted using the current gid as primary group
+func lookupUser(userName string) (uid, gid int, err error) {
+	found, err := user.Lookup(userName)
+	if err != nil {
+		id, convErr := strconv.Atoi(userName)
+		if convErr != nil {
+			return
+		}
+		if found, err = user.LookupId(userName); err != nil {
+			return id, os.Getegid(), nil
+		}
+	}
+	if uid, err = strconv.Atoi(found.Uid); err != nil {
+		return
+	}
+	gid, err = strconv.Atoi(found.Gid)
+	return
+}
+
+// lookupGroup returns the gid of groupName, numeric ids unknown to the system are accepted
+func lookupGroup(groupName string) (gid int, err error) {
+	found, err := user.LookupGroup(groupName)
+	if err != nil {
+		id, convErr := strconv.Atoi(groupName)
+		if convErr != nil {
+			return
+		}
+		return id, nil
+	}
+	return strconv.Atoi(found.Gid)
+}
@@ -0,0 +1,215 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunCommandProcessOptions(t *testing.T) {
+	testCases := []struct {
+		cmd            []string
+		options        CommandOptions
+		expectedStdout string
+		root           bool
+	}{
+		{[]string{"sh", "-c", "umask"}, CommandOptions{Umask: "027"}, "^0?027\n$", false},
+		{[]string{"sh", "-c", "umask"}, CommandOptions{Umask: "0077"}, "^0?077\n$", false},
+		{[]string{"sh", "-c", `umask; echo "$0 $1"`, "arg 0", "arg 1"}, CommandOptions{Umask: "022"}, "^0?022\narg 0 arg 1\n$", false},
+		{[]string{"id", "-u"}, CommandOptions{User: "nobody"}, "^65534\n$", true},
+		{[]string{"id", "-g"}, CommandOptions{User: "nobody", Group: "65533"}, "^65533\n$", true},
+	}
+
+	// The umask is applied to the command only, never to githook
+	previous := syscall.Umask(022)
+	defer syscall.Umask(previous)
+
+	for i, test := range testCases {
+		if test.root && os.Geteuid() != 0 {
+			continue
+		}
+		got := RunCommandWithOptions(test.cmd, 10, test.options)
+		if mask := syscall.Umask(022); mask != 022 {
+			t.Errorf("%02d. RunCommandWithOptions must not change the githook umask, got %04o", i, mask)
+		}
+
+		if got.Err != "" {
+			t.Errorf("%02d. RunCommandWithOptions should not throw error with %#v but got %s", i, test.options, got.Err)
+		}
+		if match, _ := regexp.Match(test.expectedStdout, got.Stdout); !match {
+			t.Errorf("%02d. RunCommandWithOptions STDOUT does not match, expected %v but got %s", i, test.expectedStdout, got.Stdout)
+		}
+	}
+}
+
+func TestRunCommandUmaskNotFound(t *testing.T) {
+	got := RunCommandWithOptions([]string{"ifthiscommandexistsiwillfail"}, 10, CommandOptions{Umask: "022"})
+	if got.Err == "" || got.ExitCode != -1 || got.StartedAt.IsZero() {
+		t.Errorf("RunCommandWithOptions should fail to start unknown commands with umask, got %#v", got)
+	}
+}
+
+func TestValidateProcessOptions(t *testing.T) {
+	testCases := []struct {
+		user  string
+		group string
+		umask string
+		err   bool
+	}{
+		{"", "", "", false},
+		{"", "", "022", false},
+		{"", "", "9", true},
+		{"this-user-does-not-exist", "", "", true},
+		{"", "this-group-does-not-exist", "", true},
+		{"nobody", "", "", os.Geteuid() != 0},
+	}
+
+	for i, test := range testCases {
+		err := validateProcessOptions(test.user, test.group, test.umask)
+		if test.err && err == nil {
+			t.Errorf("%02d. validateProcessOptions should fail with %s, %s and %s", i, test.user, test.group, test.umask)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. validateProcessOptions should not fail with %s, %s and %s, got %s", i, test.user, test.group, test.umask, err)
+		}
+	}
+}
+
+func TestRunCommandStatus(t *testing.T) {
+	testCases := []struct {
+		cmd      []string
+		timeout  int
+		exitCode int
+		signal   string
+		timedOut bool
+	}{
+		{[]string{"true"}, 10, 0, "", false},
+		{[]string{"false"}, 10, 1, "", false},
+		{[]string{"sh", "-c", "exit 3"}, 10, 3, "", false},
+		{[]string{"sh", "-c", "kill -9 $$"}, 10, -1, "killed", false},
+		{[]string{"sleep", "5"}, 1, -1, "killed", true},
+		{[]string{"ifthiscommandexistsiwillfail"}, 10, -1, "", false},
+	}
+
+	for i, test := range testCases {
+		got := RunCommand(test.cmd, test.timeout)
+		if got.ExitCode != test.exitCode {
+			t.Errorf("%02d. RunCommand exit code should be %d, got %d", i, test.exitCode, got.ExitCode)
+		}
+		if got.Signal != test.signal {
+			t.Errorf("%02d. RunCommand signal should be %q, got %q", i, test.signal, got.Signal)
+		}
+		if got.TimedOut != test.timedOut {
+			t.Errorf("%02d. RunCommand timed out should be %v, got %v", i, test.timedOut, got.TimedOut)
+		}
+		if got.StartedAt.IsZero() || got.FinishedAt.Before(got.StartedAt) || got.Duration < 0 {
+			t.Errorf("%02d. RunCommand returned invalid timestamps %v - %v (%f)", i, got.StartedAt, got.FinishedAt, got.Duration)
+		}
+	}
+}
+
+func TestRunCommandKillGrace(t *testing.T) {
+	testCases := []struct {
+		cmd       []string
+		killGrace int
+		signal    string
+		maxTime   float64
+	}{
+		// Children must be terminated along with the command, they keep stdout open otherwise
+		{[]string{"sh", "-c", "sleep 30 & sleep 30; wait"}, 5, "terminated", 3},
+		{[]string{"sh", "-c", "trap 'exit 0' TERM; sleep 30 & wait"}, 5, "", 3},
+		{[]string{"sh", "-c", "trap '' TERM; sleep 30"}, 1, "killed", 4},
+		{[]string{"sh", "-c", "trap '' TERM; sleep 30"}, 0, "killed", 3},
+	}
+
+	for i, test := range testCases {
+		got := RunCommandWithOptions(test.cmd, 1, CommandOptions{KillGrace: test.killGrace})
+		if !got.TimedOut || got.Err == "" {
+			t.Errorf("%02d. RunCommandWithOptions should time out with %v, got %#v", i, test.cmd, got)
+		}
+		if got.Signal != test.signal {
+			t.Errorf("%02d. RunCommandWithOptions signal should be %q, got %q", i, test.signal, got.Signal)
+		}
+		if got.Duration > test.maxTime {
+			t.Errorf("%02d. RunCommandWithOptions should finish in less than %f seconds, got %f", i, test.maxTime, got.Duration)
+		}
+	}
+}
+
+func TestRunCommandDetachedChild(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not available")
+	}
+	// The detached sleep is not killed along with the command but keeps its stdout open
+	got := RunCommandWithOptions([]string{"sh", "-c", "echo started; setsid sleep 30 & sleep 30"}, 1, CommandOptions{})
+	if !got.TimedOut || got.Signal != "killed" {
+		t.Errorf("RunCommandWithOptions should time out, got %#v", got)
+	}
+	if string(got.Stdout) != "started\n" {
+		t.Errorf("RunCommandWithOptions should keep the output written before timing out, got %q", got.Stdout)
+	}
+	if got.Duration > 5 {
+		t.Errorf("RunCommandWithOptions should not wait for detached children, got %f seconds", got.Duration)
+	}
+}
+
+func TestRunCommandCancel(t *testing.T) {
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+	got := RunCommandWithOptions([]string{"sh", "-c", "sleep 30 & sleep 30; wait"}, 30, CommandOptions{KillGrace: 5, Cancel: cancel})
+	if !got.Cancelled || got.TimedOut || got.Err != "Command cancelled" || got.Signal != "terminated" {
+		t.Errorf("RunCommandWithOptions should be cancelled, got %#v", got)
+	}
+	if got.Duration > 3 {
+		t.Errorf("RunCommandWithOptions should finish right after being cancelled, got %f seconds", got.Duration)
+	}
+
+	got = RunCommandWithOptions([]string{"true"}, 30, CommandOptions{Cancel: make(chan struct{})})
+	if got.Cancelled || got.Err != "" {
+		t.Errorf("RunCommandWithOptions should not be cancelled, got %#v", got)
+	}
+}
+
+func TestRunCommandOutput(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "githook-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+	outputFile := filepath.Join(outputDir, "output.log")
+
+	// Filling the stderr pipe while stdout is not finished must not block the command
+	cmd := []string{"sh", "-c", "head -c 200000 /dev/zero | tr '\\0' e >&2; echo done"}
+	got := RunCommandWithOptions(cmd, 10, CommandOptions{MaxOutput: 100, OutputFile: outputFile})
+	if got.Err != "" || got.TimedOut {
+		t.Fatalf("RunCommandWithOptions should not fail, got %s", got.Err)
+	}
+	if string(got.Stdout) != "done\n" || got.OutputFile != outputFile || !got.Truncated {
+		t.Errorf("RunCommandWithOptions result does not match, got %#v", got)
+	}
+	if expected := strings.Repeat("e", 50) + "\n... [199900 bytes truncated] ...\n" + strings.Repeat("e", 50); string(got.Stderr) != expected {
+		t.Errorf("RunCommandWithOptions stderr should be truncated, got %q", got.Stderr)
+	}
+	output, _ := ioutil.ReadFile(outputFile)
+	if len(output) != 200005 || !strings.Contains(string(output), "done\n") {
+		t.Errorf("Output file should contain the whole output, got %d bytes", len(output))
+	}
+
+	got = RunCommandWithOptions([]string{"echo", "again"}, 10, CommandOptions{OutputFile: outputFile})
+	if output, _ = ioutil.ReadFile(outputFile); len(output) != 200011 || got.Truncated {
+		t.Errorf("Output file should be appended, got %d bytes", len(output))
+	}
+
+	got = RunCommandWithOptions([]string{"true"}, 10, CommandOptions{OutputFile: filepath.Join(outputDir, "missing", "output.log")})
+	if got.Err == "" {
+		t.Error("RunCommandWithOptions should fail when the output file cannot be created")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// startCommand starts command, user, group and umask settings are not supported on Windows
+// It returns error if any of them is set or the command cannot be started
+func startCommand(command *exec.Cmd, options CommandOptions) (err error) {
+	if err = validateProcessOptions(options.User, options.Group, options.Umask); err != nil {
+		return
+	}
+	return command.Start()
+}
+
+// terminateCommand kills the command process, as there is no SIGTERM on Windows
+func terminateCommand(command *exec.Cmd) {
+	command.Process.Kill()
+}
+
+// killCommand kills the command process
+func killCommand(command *exec.Cmd) {
+	command.Process.Kill()
+}
+
+// validateProcessOptions checks that userName, groupName and umask can be applied to the commands
+// It returns error if any of them is set as they are not supported on Windows
+func validateProcessOptions(userName, groupName, umask string) (err error) {
+	if userName != "" || groupName != "" || umask != "" {
+		return errors.New("User, group and umask settings are not supported on Windows")
+	}
+	return
+}
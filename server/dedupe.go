@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// deliveryHeaders holds the headers identifying a delivery, in the order they are looked up
+// GitHub sets X-GitHub-Delivery, GitLab X-Gitlab-Event-UUID, Bitbucket X-Request-UUID, Gitea, Forgejo and Gogs
+// X-Gitea-Delivery, X-Forgejo-Delivery and X-Gogs-Delivery and Bitbucket Server X-Request-Id. X-Request-Id is
+// looked up last as proxies may set it too
+var deliveryHeaders = []string{
+	"X-GitHub-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Request-UUID",
+	"X-Gitea-Delivery",
+	"X-Forgejo-Delivery",
+	"X-Gogs-Delivery",
+	"X-Request-Id",
+}
+
+// deliveryID returns the identifier of the delivery received at request, the first delivery header found
+// or the SHA-256 hash of body when the provider does not set any
+func deliveryID(request *http.Request, body []byte) string {
+	for _, header := range deliveryHeaders {
+		if id := request.Header.Get(header); id != "" {
+			return id
+		}
+	}
+	hash := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// delivery is a deliveryCache entry, the delivery id was received at the job jobID and it is remembered until expires
+type delivery struct {
+	id      string
+	jobID   string
+	expires time.Time
+}
+
+// deliveryCache remembers the deliveries received by a hook during window, so redelivered webhooks
+// can be told apart from new ones, see Hook.DedupeWindow
+// It is safe for concurrent use
+type deliveryCache struct {
+	mutex      sync.Mutex
+	window     time.Duration
+	jobs       map[string]string
+	deliveries []delivery
+}
+
+// newDeliveryCache creates an empty deliveryCache remembering deliveries during window
+func newDeliveryCache(window time.Duration) *deliveryCache {
+	return &deliveryCache{window: window, jobs: make(map[string]string)}
+}
+
+// expire forgets the deliveries received before the window, the cache must be locked
+func (c *deliveryCache) expire(now time.Time) {
+	for len(c.deliveries) > 0 && !now.Before(c.deliveries[0].expires) {
+		if c.jobs[c.deliveries[0].id] == c.deliveries[0].jobID {
+			delete(c.jobs, c.deliveries[0].id)
+		}
+		c.deliveries = c.deliveries[1:]
+	}
+}
+
+// reserve remembers the delivery id as received at the job jobID, unless it was already received
+// It returns the job which received the delivery first and whether id is a duplicate
+func (c *deliveryCache) reserve(id, jobID string) (original string, duplicate bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	c.expire(now)
+	if original, found := c.jobs[id]; found {
+		return original, true
+	}
+	c.jobs[id] = jobID
+	c.deliveries = append(c.deliveries, delivery{id: id, jobID: jobID, expires: now.Add(c.window)})
+	return jobID, false
+}
+
+// release forgets the delivery id received at the job jobID, which was not run, so it can be delivered again
+func (c *deliveryCache) release(id, jobID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.jobs[id] == jobID {
+		delete(c.jobs, id)
+	}
+}
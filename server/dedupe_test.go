@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeliveryID(t *testing.T) {
+	testCases := []struct {
+		headers  map[string]string
+		body     string
+		expected string
+	}{
+		{map[string]string{"X-GitHub-Delivery": "72d3162e-cc78-11e3-81ab-4c9367dc0958"}, "{}", "72d3162e-cc78-11e3-81ab-4c9367dc0958"},
+		{map[string]string{"X-Gitlab-Event-UUID": "13792a34-cac6-4fda-95a8-c58e563d3e4b"}, "{}", "13792a34-cac6-4fda-95a8-c58e563d3e4b"},
+		{map[string]string{"X-Request-UUID": "afe8cfd8-4a9d-4c8a-a1e1-1e5cd3ac8e29"}, "{}", "afe8cfd8-4a9d-4c8a-a1e1-1e5cd3ac8e29"},
+		{map[string]string{"X-GitHub-Delivery": "github-id", "X-Request-UUID": "bitbucket-id"}, "{}", "github-id"},
+		{map[string]string{"X-Gitea-Delivery": "gitea-id", "X-Forgejo-Delivery": "gitea-id"}, "{}", "gitea-id"},
+		{map[string]string{"X-Forgejo-Delivery": "forgejo-id"}, "{}", "forgejo-id"},
+		{map[string]string{"X-Gogs-Delivery": "gogs-id"}, "{}", "gogs-id"},
+		{map[string]string{"X-Request-Id": "bitbucket-server-id"}, "{}", "bitbucket-server-id"},
+		{map[string]string{"X-Request-Id": "proxy-id", "X-Gitea-Delivery": "gitea-id"}, "{}", "gitea-id"},
+		{map[string]string{}, "{}", "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},
+		{map[string]string{"X-Event-Key": "repo:push"}, "", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+
+	for i, test := range testCases {
+		req, _ := http.NewRequest("POST", "/", nil)
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		if got := deliveryID(req, []byte(test.body)); got != test.expected {
+			t.Errorf("%02d. deliveryID returned %s, expected %s", i, got, test.expected)
+		}
+	}
+}
+
+func TestDeliveryCache(t *testing.T) {
+	cache := newDeliveryCache(100 * time.Millisecond)
+	testCases := []struct {
+		id        string
+		jobID     string
+		original  string
+		duplicate bool
+	}{
+		{"delivery-1", "job-1", "job-1", false},
+		{"delivery-2", "job-2", "job-2", false},
+		{"delivery-1", "job-3", "job-1", true},
+		{"delivery-1", "job-4", "job-1", true},
+	}
+	for i, test := range testCases {
+		original, duplicate := cache.reserve(test.id, test.jobID)
+		if original != test.original || duplicate != test.duplicate {
+			t.Errorf("%02d. reserve returned %s %v, expected %s %v", i, original, duplicate, test.original, test.duplicate)
+		}
+	}
+
+	cache.release("delivery-2", "job-5")
+	if _, duplicate := cache.reserve("delivery-2", "job-6"); !duplicate {
+		t.Error("release must not forget deliveries received by other jobs")
+	}
+	cache.release("delivery-2", "job-2")
+	if original, duplicate := cache.reserve("delivery-2", "job-7"); duplicate || original != "job-7" {
+		t.Errorf("Released deliveries must be received again, got %s", original)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, duplicate := cache.reserve("delivery-1", "job-8"); duplicate {
+		t.Error("Deliveries must be forgotten once the window passes")
+	}
+	if len(cache.deliveries) != 1 || len(cache.jobs) != 1 {
+		t.Errorf("Expired deliveries must be removed, got %v", cache.jobs)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Wiston999/githook/event"
+)
+
+// Filters holds the conditions a repository event must match for the hook command to be executed,
+// empty conditions match any event
+// Branches is a list of patterns matched against the event Branch (tag name for tag_push and release events)
+// Authors is a list of patterns matched against the event Author, ExcludeAuthors is a list of patterns
+// of authors whose events are skipped, even if they match Authors
+// Kinds is a list of event kinds, i.e.: push, tag_push, pull_request...
+// Paths is a list of patterns matched against the files changed by the event, events without changed
+// files never match it as only some providers send that information (github, gitlab and gitea pushes)
+// Patterns are globs where * matches any sequence of characters except /, ** matches any sequence of
+// characters and ? matches any single character except /. Patterns prefixed by re: are treated as
+// regular expressions, i.e.: `re:^release-[0-9]+$`
+type Filters struct {
+	Branches       []string
+	Authors        []string
+	ExcludeAuthors []string `yaml:"exclude_authors"`
+	Kinds          []string
+	Paths          []string
+}
+
+// filter is the compiled form of Filters
+type filter struct {
+	branches       []*regexp.Regexp
+	authors        []*regexp.Regexp
+	excludeAuthors []*regexp.Regexp
+	kinds          []string
+	paths          []*regexp.Regexp
+}
+
+// filterKinds holds the event kinds accepted by Filters.Kinds
+var filterKinds = []string{event.KindPush, event.KindTagPush, event.KindPullRequest, event.KindMergeRequest, event.KindRelease}
+
+// compile compiles the Filters patterns into a filter
+// It returns error if any of the patterns is not valid or any of the kinds is unknown
+func (f Filters) compile() (compiled filter, err error) {
+	if compiled.branches, err = compilePatterns(f.Branches); err != nil {
+		return
+	}
+	if compiled.authors, err = compilePatterns(f.Authors); err != nil {
+		return
+	}
+	if compiled.excludeAuthors, err = compilePatterns(f.ExcludeAuthors); err != nil {
+		return
+	}
+	if compiled.paths, err = compilePatterns(f.Paths); err != nil {
+		return
+	}
+	for _, kind := range f.Kinds {
+		if !containsString(filterKinds, kind) {
+			return compiled, fmt.Errorf("Unknown event kind %q, it must be one of: %s", kind, strings.Join(filterKinds, ", "))
+		}
+	}
+	compiled.kinds = f.Kinds
+	return
+}
+
+// match checks if the repository event matches the filter
+// It returns false and the reason when the event does not match
+func (f filter) match(e event.RepoEvent) (matched bool, reason string) {
+	if len(f.kinds) > 0 && !containsString(f.kinds, e.Kind) {
+		return false, fmt.Sprintf("Event kind %s does not match %v", e.Kind, f.kinds)
+	}
+	if len(f.branches) > 0 && !matchAny(f.branches, e.Branch) {
+		return false, fmt.Sprintf("Branch %s does not match any branch filter", e.Branch)
+	}
+	if len(f.authors) > 0 && !matchAny(f.authors, e.Author) {
+		return false, fmt.Sprintf("Author %s does not match any author filter", e.Author)
+	}
+	if matchAny(f.excludeAuthors, e.Author) {
+		return false, fmt.Sprintf("Author %s is excluded", e.Author)
+	}
+	if len(f.paths) > 0 && len(e.ChangedPaths) == 0 {
+		return false, "Event does not include the changed paths required by the path filter"
+	}
+	if len(f.paths) > 0 {
+		pathMatched := false
+		for _, path := range e.ChangedPaths {
+			if pathMatched = matchAny(f.paths, path); pathMatched {
+				break
+			}
+		}
+		if !pathMatched {
+			return false, "None of the changed paths match any path filter"
+		}
+	}
+	return true, ""
+}
+
+// compilePatterns compiles a list of glob or regular expression (prefixed by re:) patterns
+func compilePatterns(patterns []string) (compiled []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		expr := globToRegexp(pattern)
+		if strings.HasPrefix(pattern, "re:") {
+			expr = strings.TrimPrefix(pattern, "re:")
+		}
+		re, compileErr := regexp.Compile(expr)
+		if compileErr != nil {
+			return nil, fmt.Errorf("Invalid pattern %s: %s", pattern, compileErr)
+		}
+		compiled = append(compiled, re)
+	}
+	return
+}
+
+// globToRegexp translates a glob pattern into an anchored regular expression
+func globToRegexp(glob string) string {
+	var expr []string
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr = append(expr, "(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr = append(expr, ".*")
+			i++
+		case c == '*':
+			expr = append(expr, "[^/]*")
+		case c == '?':
+			expr = append(expr, "[^/]")
+		default:
+			expr = append(expr, regexp.QuoteMeta(string(c)))
+		}
+	}
+	return "^" + strings.Join(expr, "") + "$"
+}
+
+// matchAny returns true if s matches any of the regular expressions
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
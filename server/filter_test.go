@@ -0,0 +1,120 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Wiston999/githook/event"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	testCases := []struct {
+		glob    string
+		value   string
+		matched bool
+	}{
+		{"master", "master", true},
+		{"master", "master2", false},
+		{"release-*", "release-1.0", true},
+		{"release-*", "release-1.0/hotfix", false},
+		{"feature/*", "feature/foo", true},
+		{"feature/*", "feature/foo/bar", false},
+		{"feature/**", "feature/foo/bar", true},
+		{"v?.?", "v1.0", true},
+		{"v?.?", "v10.0", false},
+		{"docs/**/*.md", "docs/README.md", true},
+		{"docs/**/*.md", "docs/api/v1/README.md", true},
+		{"docs/**/*.md", "src/README.md", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "server/handler.go", true},
+		{"*.go", "server/handler.go", false},
+		{"(a+b)", "(a+b)", true},
+		{"(a+b)", "aab", false},
+	}
+
+	for i, test := range testCases {
+		patterns, err := compilePatterns([]string{test.glob})
+		if err != nil {
+			t.Errorf("%02d. compilePatterns should not fail with %s, got %s", i, test.glob, err)
+			continue
+		}
+		if matched := matchAny(patterns, test.value); matched != test.matched {
+			t.Errorf("%02d. Glob %s matching %s should be %v, got %v", i, test.glob, test.value, test.matched, matched)
+		}
+	}
+}
+
+func TestFiltersCompile(t *testing.T) {
+	testCases := []struct {
+		filters Filters
+		err     bool
+	}{
+		{Filters{}, false},
+		{Filters{Branches: []string{"master", "re:^release-[0-9]+$"}}, false},
+		{Filters{Branches: []string{"re:(unclosed"}}, true},
+		{Filters{Authors: []string{"re:(unclosed"}}, true},
+		{Filters{ExcludeAuthors: []string{"re:(unclosed"}}, true},
+		{Filters{Paths: []string{"re:(unclosed"}}, true},
+		{Filters{Kinds: []string{"push", "tag_push", "pull_request", "merge_request", "release"}}, false},
+		{Filters{Kinds: []string{"push", "tag-push"}}, true},
+	}
+
+	for i, test := range testCases {
+		_, err := test.filters.compile()
+		if test.err && err == nil {
+			t.Errorf("%02d. Filters compile should fail with %#v", i, test.filters)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. Filters compile should not fail with %#v, got %s", i, test.filters, err)
+		}
+	}
+}
+
+func TestFiltersMatch(t *testing.T) {
+	repoEvent := event.RepoEvent{
+		Kind:         event.KindPush,
+		Author:       "Wiston999",
+		Branch:       "feature/filters",
+		Commit:       "eddf11a4056b1abc8002c005ddc0a20cd5f1038a",
+		ChangedPaths: []string{"README.md", "server/filter.go"},
+	}
+
+	testCases := []struct {
+		filters Filters
+		event   event.RepoEvent
+		matched bool
+	}{
+		{Filters{}, repoEvent, true},
+		{Filters{Kinds: []string{"push", "tag_push"}}, repoEvent, true},
+		{Filters{Kinds: []string{"tag_push"}}, repoEvent, false},
+		{Filters{Branches: []string{"master", "feature/*"}}, repoEvent, true},
+		{Filters{Branches: []string{"master"}}, repoEvent, false},
+		{Filters{Branches: []string{"re:^feature/"}}, repoEvent, true},
+		{Filters{Branches: []string{"re:^release/"}}, repoEvent, false},
+		{Filters{Authors: []string{"Wiston999"}}, repoEvent, true},
+		{Filters{Authors: []string{"someone-else"}}, repoEvent, false},
+		{Filters{ExcludeAuthors: []string{"*bot*"}}, repoEvent, true},
+		{Filters{ExcludeAuthors: []string{"Wiston*"}}, repoEvent, false},
+		{Filters{Authors: []string{"*"}, ExcludeAuthors: []string{"Wiston999"}}, repoEvent, false},
+		{Filters{Paths: []string{"server/**"}}, repoEvent, true},
+		{Filters{Paths: []string{"*.md"}}, repoEvent, true},
+		{Filters{Paths: []string{"docs/**"}}, repoEvent, false},
+		{Filters{Paths: []string{"docs/**"}}, event.RepoEvent{Branch: "master"}, false},
+		{Filters{Paths: []string{"**"}}, event.RepoEvent{Kind: event.KindPush, Branch: "master", Deleted: true}, false},
+		{Filters{Kinds: []string{"push"}}, event.RepoEvent{Kind: event.KindPush, Branch: "master"}, true},
+		{Filters{Kinds: []string{"push"}, Branches: []string{"feature/*"}, Paths: []string{"docs/**"}}, repoEvent, false},
+	}
+
+	for i, test := range testCases {
+		compiled, err := test.filters.compile()
+		if err != nil {
+			t.Errorf("%02d. Filters compile should not fail with %#v, got %s", i, test.filters, err)
+			continue
+		}
+		matched, reason := compiled.match(test.event)
+		if matched != test.matched {
+			t.Errorf("%02d. Filters %#v matching should be %v, got %v", i, test.filters, test.matched, matched)
+		}
+		if !matched && reason == "" {
+			t.Errorf("%02d. Filters must return a reason when the event does not match", i)
+		}
+	}
+}
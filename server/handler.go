@@ -1,12 +1,18 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Wiston999/githook/event"
 
@@ -78,39 +84,142 @@ func CommandLogRESTHandler(cmdLog CommandLog) func(http.ResponseWriter, *http.Re
 // RepoRequestHandler setups an http.HandlerFunc using Hook information
 // This function makes the hard work of setting up a listener hook on the HTTP Server
 // based on an Hook structure
-func RepoRequestHandler(cmdLog CommandLog, workerChannel chan CommandJob, hookName string, hookInfo Hook) func(http.ResponseWriter, *http.Request) {
+// Jobs are tracked at jobs, requests are answered with 202 and the job status, whose URL is
+// set at the Location header, or with the job result once it finishes when the sync parameter is given
+// When the hook queue is full the hook Overflow policy is applied, rejected requests are answered with 503
+// When the hook coalesces jobs, the queued or debounced job with the same key is cancelled as superseded,
+// as well as the running one when the hook cancels jobs in progress
+// Redelivered webhooks are answered with 200 and the status of the job which received the delivery first,
+// when the hook remembers deliveries
+func RepoRequestHandler(cmdLog CommandLog, workerChannel chan CommandJob, jobs *JobStore, hookName string, hookInfo Hook) func(http.ResponseWriter, *http.Request) {
+	parser, parserErr := hookInfo.Parser()
+	filter, filterErr := hookInfo.Filters.compile()
+	overflowTimeout := hookInfo.OverflowTimeout
+	if overflowTimeout == 0 {
+		overflowTimeout = defaultOverflowTimeout
+	}
+	jobsByKey := newCoalescer()
+	var deliveries *deliveryCache
+	if hookInfo.DedupeWindow > 0 {
+		deliveries = newDeliveryCache(time.Duration(hookInfo.DedupeWindow) * time.Second)
+	}
+	// supersede cancels the job previous, with the same key as the newer job id, see JobStore.Supersede
+	supersede := func(previous, id, key string, running bool) {
+		if previous == "" {
+			return
+		}
+		if status, supersedeErr := jobs.Supersede(previous, id, running); supersedeErr == nil {
+			log.WithFields(log.Fields{
+				"hook":  hookName,
+				"key":   key,
+				"jobId": previous,
+				"state": status.State,
+				"reqId": id,
+			}).Info("Job superseded by a newer event")
+		}
+	}
+	// queueMutex serializes the coalesced jobs sent to the worker channel, as superseded jobs are removed from it
+	var queueMutex sync.Mutex
+	// queue sends job to the worker channel, cancelling the queued job it supersedes at key
+	queue := func(job CommandJob, key string) (err error) {
+		if hookInfo.coalesced() {
+			queueMutex.Lock()
+			defer queueMutex.Unlock()
+		}
+		dropped, err := enqueue(workerChannel, job, hookInfo.Overflow, overflowTimeout)
+		if err == errQueueFull && hookInfo.coalesced() {
+			// Superseded jobs would be skipped by the workers, they are removed to make room for job
+			supersede(jobsByKey.latestJob(key), job.ID, key, false)
+			for _, superseded := range removeCancelled(workerChannel, jobs) {
+				status, _ := jobs.Get(superseded.ID)
+				cancelQueuedJob(cmdLog, jobs, hookName, superseded, status, false)
+			}
+			dropped, err = enqueue(workerChannel, job, OverflowReject, 0)
+		}
+		for _, oldest := range dropped {
+			cancelQueuedJob(cmdLog, jobs, hookName, oldest, JobStatus{Reason: "Dropped from a full queue"}, true)
+		}
+		if err != nil || !hookInfo.coalesced() {
+			return
+		}
+		supersede(jobsByKey.replace(key, job.ID), job.ID, key, hookInfo.CancelInProgress)
+		return
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		requestID := r.Context().Value("requestID").(string)
-		repoEvent := &event.RepoEvent{}
 		var response Response
 		var err error
 		urlQuery := r.URL.Query()
 		_, sync := urlQuery["sync"]
 
-		switch hookInfo.Type {
-		case "bitbucket":
-			localEvent, localErr := event.NewBitbucketEvent(r)
-			if localErr != nil {
-				repoEvent, err = nil, localErr
-			} else {
-				repoEvent, err = localEvent, localErr
+		if parserErr != nil {
+			response.Status, response.Msg = 500, fmt.Sprintf("Unable to setup repository parser: %s", parserErr)
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		if filterErr != nil {
+			response.Status, response.Msg = 500, fmt.Sprintf("Unable to setup hook filters: %s", filterErr)
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			body, err = ioutil.ReadAll(r.Body)
+			if err != nil {
+				response.Status, response.Msg = 500, fmt.Sprintf("Unable to read request body: %s", err)
+				w.WriteHeader(500)
+				json.NewEncoder(w).Encode(response)
+				return
 			}
-		case "github":
-			localEvent, localErr := event.NewGithubEvent(r)
-			if localErr != nil {
-				repoEvent, err = nil, localErr
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		if hookInfo.Secret != "" {
+			if validator, ok := parser.(event.Validator); ok {
+				err = validator.Validate(r, body, hookInfo.Secret)
 			} else {
-				repoEvent, err = localEvent, localErr
+				err = errors.New("Repository type does not support request validation")
 			}
-		case "gitlab":
-			localEvent, localErr := event.NewGitlabEvent(r)
-			if localErr != nil {
-				repoEvent, err = nil, localErr
-			} else {
-				repoEvent, err = localEvent, localErr
+			if err != nil {
+				log.WithFields(log.Fields{
+					"hook":   hookName,
+					"reqId":  requestID,
+					"remote": r.RemoteAddr,
+					"err":    err,
+				}).Warn("Request validation failed, delivery rejected")
+				cmdLog.AppendResult(CommandResult{
+					ID:       requestID,
+					Hook:     hookName,
+					Remote:   r.RemoteAddr,
+					Rejected: err.Error(),
+				})
+				// Missing credentials are answered with 401, credentials which do not match with 403
+				response.Status = 403
+				if _, missing := err.(event.MissingCredentialsError); missing {
+					response.Status = 401
+				}
+				response.Msg = fmt.Sprintf("Request validation failed: %s", err)
+				w.WriteHeader(response.Status)
+				json.NewEncoder(w).Encode(response)
+				return
 			}
 		}
 
+		repoEvent, err := parser.Parse(r)
+		if unsupported, ok := err.(event.UnsupportedEventError); ok {
+			log.WithFields(log.Fields{
+				"hook":  hookName,
+				"reqId": requestID,
+				"event": unsupported.Event,
+			}).Info("Unsupported event skipped")
+			response.Status, response.Msg, response.Body = 200, "Event skipped", unsupported.Error()
+			json.NewEncoder(w).Encode(response)
+			return
+		}
 		if err != nil {
 			response.Status, response.Msg = 500, fmt.Sprintf("Error while parsing event: %s", err)
 			w.WriteHeader(500)
@@ -118,38 +227,358 @@ func RepoRequestHandler(cmdLog CommandLog, workerChannel chan CommandJob, hookNa
 			return
 		}
 
-		if repoEvent.Branch == "" {
-			response.Status, response.Msg = 500, "Repository type is unknown"
+		if repoEvent != nil && repoEvent.Kind == event.KindPing {
+			log.WithFields(log.Fields{"hook": hookName, "reqId": requestID}).Info("Ping event received")
+			response.Status, response.Msg = 200, "Ping event received, no command executed"
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		if repoEvent == nil || repoEvent.Branch == "" {
+			response.Status, response.Msg = 500, "Unable to parse repository event"
 			w.WriteHeader(500)
 			json.NewEncoder(w).Encode(response)
 			return
 		}
 
+		repoEvent.SetRequest(r, body)
 		log.Debug("Repository event parsed: ", repoEvent)
-		cmd, err := TranslateParams(hookInfo.Cmd, *repoEvent)
+		if matched, reason := filter.match(*repoEvent); !matched {
+			log.WithFields(log.Fields{
+				"hook":   hookName,
+				"reqId":  requestID,
+				"reason": reason,
+			}).Info("Event skipped by hook filters")
+			response.Status, response.Msg, response.Body = 200, "Event skipped", reason
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		cmdJob, err := hookInfo.job(hookName, requestID, *repoEvent, body)
 		if err != nil {
-			response.Status, response.Msg = 500, fmt.Sprintf("Unable to translate hook command template (%s): %s", hookName, err)
+			response.Status, response.Msg = 500, fmt.Sprintf("Unable to setup hook command (%s): %s", hookName, err)
 			w.WriteHeader(500)
 			json.NewEncoder(w).Encode(response)
 			return
 		}
-
-		cmdJob := CommandJob{Cmd: cmd, ID: requestID, Timeout: hookInfo.Timeout}
+		var key string
+		if hookInfo.coalesced() {
+			if key, err = hookInfo.coalesceKey(*repoEvent); err != nil {
+				response.Status, response.Msg = 500, fmt.Sprintf("Unable to setup hook command (%s): %s", hookName, err)
+				w.WriteHeader(500)
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+		}
+		var deliveryKey string
+		if deliveries != nil {
+			deliveryKey = deliveryID(r, body)
+			if original, duplicate := deliveries.reserve(deliveryKey, requestID); duplicate {
+				log.WithFields(log.Fields{
+					"hook":     hookName,
+					"reqId":    requestID,
+					"delivery": deliveryKey,
+					"jobId":    original,
+				}).Info("Duplicate delivery, command not executed")
+				status, found := jobs.Get(original)
+				if !found {
+					status = JobStatus{ID: original, Hook: hookName}
+				}
+				response.Status, response.Msg, response.Body = 200, "Duplicate delivery, command not executed", status
+				w.Header().Set("Location", "/admin/jobs/"+original)
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+		}
 		if sync {
 			cmdJob.Response = make(chan CommandResult, 1)
 		}
-		workerChannel <- cmdJob
-		response.Status, response.Msg, response.Body = 200, "Command sent to execute", strings.Join(cmd, " ")
+		if hookInfo.coalesced() {
+			cmdJob.Finished = func() { jobsByKey.forget(key, requestID) }
+		}
+		status := jobs.Queue(hookName, cmdJob)
+		if hookInfo.Debounce > 0 {
+			dispatch := func(job CommandJob) {
+				if queueErr := queue(job, key); queueErr != nil {
+					jobs.Reject(hookName, job.ID)
+					if deliveries != nil {
+						deliveries.release(deliveryKey, job.ID)
+					}
+					rejectDebouncedJob(cmdLog, hookName, job, queueErr)
+				}
+			}
+			previous := jobsByKey.debounce(key, cmdJob, time.Duration(hookInfo.Debounce)*time.Second, dispatch)
+			if previous != nil {
+				status, _ := jobs.Supersede(previous.ID, requestID, false)
+				cancelQueuedJob(cmdLog, jobs, hookName, *previous, status, false)
+			}
+		} else {
+			err = queue(cmdJob, key)
+		}
+		response.Queue = queueStatus(workerChannel)
+		if err != nil {
+			jobs.Reject(hookName, requestID)
+			if deliveries != nil {
+				deliveries.release(deliveryKey, requestID)
+			}
+			log.WithFields(log.Fields{
+				"hook":     hookName,
+				"reqId":    requestID,
+				"overflow": hookInfo.Overflow,
+			}).Warn("Hook queue is full, request rejected")
+			response.Status, response.Msg = 503, fmt.Sprintf("Unable to queue command (%s): %s", hookName, err)
+			w.Header().Set("Retry-After", strconv.Itoa(overflowTimeout))
+			w.WriteHeader(503)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		response.Status, response.Msg, response.Body = 202, "Command sent to execute", status
 		if sync {
 			log.WithFields(log.Fields{
-				"cmd":       cmdJob.Cmd,
+				"cmd":       cmdJob.String(),
 				"queue_len": len(workerChannel),
 				"reqId":     requestID,
 			}).Info("Waiting for command to complete before returning")
 
 			result := <-cmdJob.Response
-			response.Body = result
+			response.Status, response.Body = 200, result
+		} else {
+			w.Header().Set("Location", "/admin/jobs/"+requestID)
+			w.WriteHeader(202)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// rejectDebouncedJob records job, received by hookName, as rejected because it could not be queued once its
+// debounce delay passed, as the request was already answered. It runs on the debounce timer goroutine, which
+// is fine as CommandLog implementations and JobStore are safe for concurrent use
+func rejectDebouncedJob(cmdLog CommandLog, hookName string, job CommandJob, err error) {
+	log.WithFields(log.Fields{
+		"hook":  hookName,
+		"jobId": job.ID,
+		"err":   err,
+	}).Warn("Hook queue is full, debounced job rejected")
+	result := CommandResult{
+		ID:       job.ID,
+		Hook:     hookName,
+		Event:    job.Event,
+		Cmd:      job.Cmd,
+		Err:      "Unable to queue command: " + err.Error(),
+		ExitCode: -1,
+		QueuedAt: job.QueuedAt,
+		Rejected: err.Error(),
+	}
+	cmdLog.AppendResult(result)
+	if job.Response != nil {
+		job.Response <- result
+	}
+}
+
+// cancelQueuedJob records job, received by hookName, as cancelled, as described by status, when it is removed
+// before reaching a worker. When dropped is set the job is counted as dropped from the hook queue
+func cancelQueuedJob(cmdLog CommandLog, jobs *JobStore, hookName string, job CommandJob, status JobStatus, dropped bool) {
+	log.WithFields(log.Fields{
+		"hook":   hookName,
+		"jobId":  job.ID,
+		"reason": status.Reason,
+	}).Warn("Queued job cancelled")
+	result := cancelledResult(hookName, job, status)
+	cmdLog.AppendResult(result)
+	if dropped {
+		jobs.Drop(hookName, job.ID, result)
+	} else {
+		jobs.Finish(job.ID, result)
+	}
+	if job.Finished != nil {
+		job.Finished()
+	}
+	if job.Response != nil {
+		job.Response <- result
+	}
+}
+
+// HookMetrics holds a hook queue status and jobs statistics
+type HookMetrics struct {
+	Queue *QueueStatus `json:"queue"`
+	HookStats
+}
+
+// MetricsHandler returns the HookMetrics of every hook, given their queues and the jobs statistics at jobs
+func MetricsHandler(queues map[string]chan CommandJob, jobs *JobStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := jobs.Stats()
+		metrics := make(map[string]HookMetrics)
+		for hookName, queue := range queues {
+			hookStats, found := stats[hookName]
+			if !found {
+				hookStats.Finished = make(map[string]uint64)
+			}
+			metrics[hookName] = HookMetrics{Queue: queueStatus(queue), HookStats: hookStats}
 		}
+		response := Response{Status: 200, Msg: "success", Body: metrics}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// JobsHandler serves the jobs API under /admin/jobs/, jobs are looked for at jobs and, once they
+// are not tracked anymore, at cmdLog:
+// GET /admin/jobs/{id} returns the job status, see JobStatus
+// DELETE /admin/jobs/{id} cancels a queued job
+// GET /admin/jobs/{id}/stream streams the job output, see jobStreamHandler
+func JobsHandler(jobs *JobStore, cmdLog CommandLog) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"), "/")
+		switch {
+		case len(path) == 1 && path[0] != "":
+			jobStatusHandler(w, r, jobs, cmdLog, path[0])
+		case len(path) == 2 && path[0] != "" && path[1] == "stream":
+			jobStreamHandler(w, r, jobs, cmdLog, path[0])
+		default:
+			response := Response{Status: 404, Msg: "Not found, use /admin/jobs/{id} or /admin/jobs/{id}/stream"}
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(response)
+		}
+	}
+}
+
+// jobStatusHandler returns the status of the job id or cancels it when the request method is DELETE
+func jobStatusHandler(w http.ResponseWriter, r *http.Request, jobs *JobStore, cmdLog CommandLog, id string) {
+	response := Response{Status: 200, Msg: "success"}
+	switch r.Method {
+	case "GET":
+		status, found := jobs.Get(id)
+		if !found {
+			result, err := findResult(cmdLog, id)
+			if err != nil {
+				response.Status, response.Msg = 500, fmt.Sprintf("Unable to read command log: %s", err)
+				break
+			}
+			if result == nil {
+				response.Status, response.Msg = 404, fmt.Sprintf("Job %s not found", id)
+				break
+			}
+			status = resultStatus(*result)
+		}
+		response.Body = status
+	case "DELETE":
+		status, err := jobs.Cancel(id, "Cancelled by request")
+		if err != nil {
+			response.Status, response.Msg = 409, err.Error()
+			if status.ID == "" {
+				response.Status = 404
+			}
+			break
+		}
+		response.Msg, response.Body = "Job cancelled", status
+	default:
+		response.Status, response.Msg = 405, "Method not allowed, use GET or DELETE"
+	}
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// jobStreamHandler streams the output of the job id as Server-Sent Events
+// The output written so far and every new chunk are sent as output events, with a data line per output line,
+// followed by a status event with the job result (without its output) once the job finishes.
+// For jobs already finished only their status event is sent
+func jobStreamHandler(w http.ResponseWriter, r *http.Request, jobs *JobStore, cmdLog CommandLog, id string) {
+	var response Response
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.Status, response.Msg = 500, "Streaming is not supported"
+		w.WriteHeader(500)
 		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	var output []byte
+	var chunks chan []byte
+	var result *CommandResult
+	stream, running := jobs.Stream(id)
+	if running {
+		output, chunks = stream.Subscribe()
+	} else {
+		var err error
+		if status, found := jobs.Get(id); found {
+			result = status.Result
+		} else if result, err = findResult(cmdLog, id); err != nil {
+			response.Status, response.Msg = 500, fmt.Sprintf("Unable to read command log: %s", err)
+		}
+		if err == nil && result == nil {
+			response.Status, response.Msg = 404, fmt.Sprintf("Job %s not found or not started", id)
+		}
+		if result == nil {
+			w.WriteHeader(response.Status)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	writeEvent(w, "output", output)
+	flusher.Flush()
+	if chunks != nil {
+		defer stream.Unsubscribe(chunks)
+	}
+	for chunks != nil {
+		select {
+		case chunk, open := <-chunks:
+			if !open {
+				chunks = nil
+				continue
+			}
+			writeEvent(w, "output", chunk)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if running {
+		if result = stream.Result(); result == nil {
+			writeEvent(w, "error", []byte("Output is produced faster than it is read, stream closed"))
+			flusher.Flush()
+			return
+		}
+	}
+	status, _ := json.Marshal(withoutOutput(*result))
+	writeEvent(w, "status", status)
+	flusher.Flush()
+}
+
+// writeEvent writes a Server-Sent Event named name with data, each data line is written as an event data line
+// Nothing is written when data is empty
+func writeEvent(w io.Writer, name string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\n", name)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// findResult looks for the result of the job id at cmdLog
+// It returns nil if the job is not found
+func findResult(cmdLog CommandLog, id string) (result *CommandResult, err error) {
+	if cmdLog == nil {
+		return
+	}
+	return cmdLog.GetResult(id)
+}
+
+// withoutOutput returns a copy of result without the command, and its steps, output
+func withoutOutput(result CommandResult) CommandResult {
+	result.Stdout, result.Stderr = nil, nil
+	steps := make([]CommandResult, 0, len(result.Steps))
+	for _, step := range result.Steps {
+		steps = append(steps, withoutOutput(step))
+	}
+	if len(steps) > 0 {
+		result.Steps = steps
 	}
+	return result
 }
@@ -3,7 +3,11 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -12,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -108,6 +113,24 @@ func TestRepoRequestHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 	glPayloadStr := string(glPayload)
+
+	gtPayload, err := ioutil.ReadFile("../payloads/gitea.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gtPayloadStr := string(gtPayload)
+
+	bsPayload, err := ioutil.ReadFile("../payloads/bitbucket-server.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bsPayloadStr := string(bsPayload)
+
+	azPayload, err := ioutil.ReadFile("../payloads/azure-devops.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	azPayloadStr := string(azPayload)
 	testCases := []struct {
 		Query   string
 		Type    string
@@ -134,6 +157,16 @@ func TestRepoRequestHandler(t *testing.T) {
 		{"gitlab", "gitlab", []string{"echo", "{{.Branch}}"}, strings.NewReader(glPayloadStr), false, false},
 		{"gitlab?sync", "gitlab", []string{"echo", "{{.Branch}}"}, strings.NewReader(glPayloadStr), true, false},
 		{"invalid-cmd", "gitlab", []string{"echo", "{{.Branch"}, strings.NewReader(glPayloadStr), false, true},
+		{"gitea", "gitea", []string{"echo", "{{.Branch}}"}, strings.NewReader(""), false, true},
+		{"gitea", "gitea", []string{"echo", "{{.Branch}}"}, strings.NewReader(bbPayloadStr), false, true},
+		{"gitea", "gitea", []string{"echo", "{{.Branch}}"}, strings.NewReader(gtPayloadStr), false, false},
+		{"gitea?sync", "gogs", []string{"echo", "{{.Repository.FullName}}"}, strings.NewReader(gtPayloadStr), true, false},
+		{"bitbucket-server", "bitbucket-server", []string{"echo", "{{.Branch}}"}, strings.NewReader(bbPayloadStr), false, true},
+		{"bitbucket-server", "bitbucket-server", []string{"echo", "{{.Branch}}"}, strings.NewReader(bsPayloadStr), false, false},
+		{"bitbucket-server?sync", "bitbucket-server", []string{"echo", "{{.Branch}}"}, strings.NewReader(bsPayloadStr), true, false},
+		{"azure-devops", "azure-devops", []string{"echo", "{{.Branch}}"}, strings.NewReader(ghPayloadStr), false, true},
+		{"azure-devops", "azure-devops", []string{"echo", "{{.Branch}}"}, strings.NewReader(azPayloadStr), false, false},
+		{"azure-devops?sync", "azure-devops", []string{"echo", "{{.Branch}}"}, strings.NewReader(azPayloadStr), true, false},
 		{"unknown-type", "unknown", []string{"echo", "{{.Branch}}"}, strings.NewReader(""), false, true},
 	}
 
@@ -154,10 +187,10 @@ func TestRepoRequestHandler(t *testing.T) {
 
 		cmdLog := NewMemoryCommandLog(100)
 		workerChannel := make(chan CommandJob, 100)
-		go CommandWorker("TestRepoRequestHandler", workerChannel, cmdLog)
+		go CommandWorker("TestRepoRequestHandler", workerChannel, cmdLog, nil)
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, "test", hook))
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
 
 		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
 		handler.ServeHTTP(rr, req.WithContext(ctx))
@@ -203,13 +236,20 @@ func TestRepoRequestHandler(t *testing.T) {
 			}
 
 		} else {
-			if status := rr.Code; status != http.StatusOK {
+			expected := http.StatusAccepted
+			if test.Sync {
+				expected = http.StatusOK
+			}
+			if status := rr.Code; status != expected {
 				t.Errorf("%02d. Handler returned wrong status code: got %v want %v",
 					i,
-					status, http.StatusOK)
+					status, expected)
+			}
+			if jsonBody.Status != expected {
+				t.Errorf("%02d. Status field in response should have %d, got %v", i, expected, jsonBody.Status)
 			}
-			if jsonBody.Status != 200 {
-				t.Errorf("%02d. Status field in response should have 200, got %v", i, jsonBody.Status)
+			if location := rr.Header().Get("Location"); !test.Sync && location != "/admin/jobs/my-request-id" {
+				t.Errorf("%02d. Location header should point to the job status, got %q", i, location)
 			}
 		}
 	}
@@ -277,3 +317,1006 @@ func TestCommandLogRESTHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestRepoRequestHandlerValidation(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bbPayload, err := ioutil.ReadFile("../payloads/bitbucket.org.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	glPayload, err := ioutil.ReadFile("../payloads/gitlab.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "my-hook-secret"
+	sign := func(payload []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	testCases := []struct {
+		Type    string
+		Secret  string
+		Header  string
+		Value   string
+		Payload []byte
+		Status  int
+	}{
+		{"github", "", "", "", ghPayload, http.StatusAccepted},
+		{"github", secret, "X-Hub-Signature-256", sign(ghPayload), ghPayload, http.StatusAccepted},
+		{"github", secret, "", "", ghPayload, http.StatusUnauthorized},
+		{"github", secret, "X-Hub-Signature-256", "sha256=0123456789abcdef", ghPayload, http.StatusForbidden},
+		{"github", secret, "X-Hub-Signature-256", "malformed", ghPayload, http.StatusForbidden},
+		{"github", "another-secret", "X-Hub-Signature-256", sign(ghPayload), ghPayload, http.StatusForbidden},
+		{"bitbucket", secret, "X-Hub-Signature", sign(bbPayload), bbPayload, http.StatusAccepted},
+		{"bitbucket", secret, "X-Hub-Signature", sign(ghPayload), bbPayload, http.StatusForbidden},
+		{"bitbucket", secret, "", "", bbPayload, http.StatusUnauthorized},
+		{"gitlab", secret, "X-Gitlab-Token", secret, glPayload, http.StatusAccepted},
+		{"gitlab", secret, "X-Gitlab-Token", "another-secret", glPayload, http.StatusForbidden},
+		{"gitlab", secret, "", "", glPayload, http.StatusUnauthorized},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        test.Type,
+			Cmd:         []string{"echo", "{{.Branch}}"},
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+			Secret:      test.Secret,
+		}
+
+		req, err := http.NewRequest("POST", test.Type, bytes.NewReader(test.Payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if test.Header != "" {
+			req.Header.Set(test.Header, test.Value)
+		}
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+
+		var jsonBody Response
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+		}
+
+		if rr.Code != test.Status || jsonBody.Status != test.Status {
+			t.Errorf("%02d. Handler returned wrong status code: got %v (%v) want %v", i, rr.Code, jsonBody.Status, test.Status)
+		}
+
+		if test.Status != http.StatusAccepted {
+			if jobs := len(workerChannel); jobs != 0 {
+				t.Errorf("%02d. Rejected requests must not enqueue jobs, got %d", i, jobs)
+			}
+			results, _ := cmdLog.GetResults(-1)
+			if len(results) != 1 || results[0].Rejected == "" || results[0].Hook != "test" {
+				t.Errorf("%02d. Rejected requests must be recorded in the command log, got %#v", i, results)
+			}
+		}
+	}
+}
+
+func TestRepoRequestHandlerGeneric(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bbPayload, err := ioutil.ReadFile("../payloads/bitbucket.org.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := map[string]string{"branch": "$.ref", "branch_regex": "^refs/heads/(.+)$", "commit": "$.after"}
+	testCases := []struct {
+		Options map[string]string
+		Cmd     []string
+		Payload []byte
+		Status  int
+	}{
+		{options, []string{"echo", "{{.Branch}}", "{{.Commit}}"}, ghPayload, http.StatusOK},
+		{options, []string{"echo", "{{.Branch}}", "{{.Commit}}"}, bbPayload, http.StatusInternalServerError},
+		{nil, []string{"echo", "{{.Branch}}"}, ghPayload, http.StatusInternalServerError},
+		{map[string]string{"commit": "$.after"}, []string{"echo", "{{.Branch}}"}, ghPayload, http.StatusInternalServerError},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        "generic",
+			Cmd:         test.Cmd,
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+			Options:     test.Options,
+		}
+
+		req, err := http.NewRequest("POST", "generic?sync", bytes.NewReader(test.Payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+		go CommandWorker("TestRepoRequestHandlerGeneric", workerChannel, cmdLog, nil)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		close(workerChannel)
+
+		var jsonBody Response
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+		}
+
+		if rr.Code != test.Status || jsonBody.Status != test.Status {
+			t.Errorf("%02d. Handler returned wrong status code: got %v (%v) want %v", i, rr.Code, jsonBody.Status, test.Status)
+		}
+
+		if test.Status == http.StatusOK {
+			bodyMap := jsonBody.Body.(map[string]interface{})
+			cmd := fmt.Sprintf("%v", bodyMap["cmd"])
+			if cmd != "[echo master eddf11a4056b1abc8002c005ddc0a20cd5f1038a]" {
+				t.Errorf("%02d. Command should be translated using generic fields, got %s", i, cmd)
+			}
+		}
+	}
+}
+
+func TestRepoRequestHandlerPing(t *testing.T) {
+	pingPayload, err := ioutil.ReadFile("../payloads/github.com-ping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Hook{
+		Type:        "github",
+		Cmd:         []string{"echo", "{{.Branch}}"},
+		Path:        "/payloadtest",
+		Timeout:     10,
+		Concurrency: 1,
+	}
+
+	// Unsupported events are skipped like ping, so providers do not retry them
+	testCases := []struct {
+		githubEvent string
+		payload     []byte
+		msg         string
+	}{
+		{"ping", pingPayload, "Ping event received, no command executed"},
+		{"issues", ghPayload, "Event skipped"},
+		{"create", ghPayload, "Event skipped"},
+	}
+
+	for i, test := range testCases {
+		req, err := http.NewRequest("POST", "github?sync", bytes.NewReader(test.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", test.githubEvent)
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+
+		var jsonBody Response
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+		}
+
+		if rr.Code != http.StatusOK || jsonBody.Status != http.StatusOK || jsonBody.Msg != test.msg {
+			t.Errorf("%02d. Handler returned wrong response for %s event: got %v %q want %v %q", i, test.githubEvent, rr.Code, jsonBody.Msg, http.StatusOK, test.msg)
+		}
+
+		if jobs := len(workerChannel); jobs != 0 {
+			t.Errorf("%02d. %s events must not enqueue jobs, got %d", i, test.githubEvent, jobs)
+		}
+	}
+}
+
+func TestRepoRequestHandlerFilters(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Filters Filters
+		Status  int
+		Msg     string
+	}{
+		{Filters{}, http.StatusAccepted, "Command sent to execute"},
+		{Filters{Branches: []string{"master"}, Paths: []string{"*.md"}}, http.StatusAccepted, "Command sent to execute"},
+		{Filters{Branches: []string{"develop"}}, http.StatusOK, "Event skipped"},
+		{Filters{Kinds: []string{"tag_push"}}, http.StatusOK, "Event skipped"},
+		{Filters{Branches: []string{"re:(unclosed"}}, http.StatusInternalServerError, ""},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        "github",
+			Cmd:         []string{"echo", "{{.Branch}}"},
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+			Filters:     test.Filters,
+		}
+
+		req, err := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+
+		var jsonBody Response
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+		}
+
+		if rr.Code != test.Status || jsonBody.Status != test.Status {
+			t.Errorf("%02d. Handler returned wrong status code: got %v (%v) want %v", i, rr.Code, jsonBody.Status, test.Status)
+		}
+		if test.Msg != "" && jsonBody.Msg != test.Msg {
+			t.Errorf("%02d. Handler returned wrong message: got %s want %s", i, jsonBody.Msg, test.Msg)
+		}
+		if jobs := len(workerChannel); test.Msg != "Command sent to execute" && jobs != 0 {
+			t.Errorf("%02d. Skipped events must not enqueue jobs, got %d", i, jobs)
+		}
+	}
+}
+
+func TestRepoRequestHandlerPayload(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Cmd      []string
+		Expected string
+	}{
+		{[]string{"echo", "{{.Payload.repository.full_name}}"}, "echo Wiston999/hello-go"},
+		{[]string{"echo", "{{index .Payload.commits 0 \"message\"}}"}, "echo Update README.md"},
+		{[]string{"echo", "{{.Headers.Get \"X-GitHub-Delivery\"}}"}, "echo 72d3162e-cc78-11e3-81ab-4c9367dc0958"},
+		{[]string{"echo", "{{index .Headers \"X-Github-Delivery\"}}"}, "echo [72d3162e-cc78-11e3-81ab-4c9367dc0958]"},
+		{[]string{"echo", "{{.Headers.Get \"X-Hub-Signature\"}}"}, "echo "},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        "github",
+			Cmd:         test.Cmd,
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+		}
+
+		req, err := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		req.Header.Set("X-Hub-Signature", "sha1=7d38cdd689735b008b3c702edd92eea23791c5f6")
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+
+		var jsonBody struct {
+			Body JobStatus
+		}
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+		}
+
+		if rr.Code != http.StatusAccepted || jsonBody.Body.Cmd != test.Expected {
+			t.Errorf("%02d. Handler returned %v (%v), expected command %q", i, rr.Code, jsonBody.Body, test.Expected)
+		}
+	}
+}
+
+func TestRepoRequestHandlerEnv(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Env      map[string]string
+		Stdin    bool
+		Expected []string
+		Status   int
+	}{
+		{nil, false, []string{"GITHOOK_HOOK=test", "GITHOOK_REQUEST_ID=my-request-id", "GITHOOK_BRANCH=master"}, http.StatusAccepted},
+		{map[string]string{"IMAGE_TAG": "{{.Branch}}-{{.Commit | shortSha}}"}, true, []string{"GITHOOK_BRANCH=master", "IMAGE_TAG=master-eddf11a"}, http.StatusAccepted},
+		{map[string]string{"BROKEN": "{{.Unknown}}"}, false, nil, http.StatusInternalServerError},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        "github",
+			Cmd:         []string{"true"},
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+			Env:         test.Env,
+			Stdin:       test.Stdin,
+		}
+
+		req, err := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+
+		if rr.Code != test.Status {
+			t.Errorf("%02d. Handler returned wrong status code: got %v want %v", i, rr.Code, test.Status)
+		}
+		if test.Status != http.StatusAccepted {
+			continue
+		}
+		if len(workerChannel) != 1 {
+			t.Errorf("%02d. Handler must enqueue 1 job, got %d", i, len(workerChannel))
+			continue
+		}
+		job := <-workerChannel
+		env := strings.Join(job.Options.Env, "\n") + "\n"
+		for _, expected := range test.Expected {
+			if !strings.Contains(env, expected+"\n") {
+				t.Errorf("%02d. Job environment must contain %s, got %v", i, expected, job.Options.Env)
+			}
+		}
+		if test.Stdin && !bytes.Equal(job.Options.Stdin, ghPayload) {
+			t.Errorf("%02d. Job stdin must be the request payload", i)
+		} else if !test.Stdin && job.Options.Stdin != nil {
+			t.Errorf("%02d. Job stdin must be empty, got %s", i, job.Options.Stdin)
+		}
+	}
+}
+
+func TestRepoRequestHandlerScript(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	maliciousPayload := strings.Replace(string(ghPayload), "refs/heads/master", "refs/heads/master;echo INJECTED$(echo INJECTED)", 1)
+
+	testCases := []struct {
+		Script   string
+		Cmd      []string
+		Payload  string
+		Expected string
+	}{
+		{`echo "$1" | tr a-z A-Z`, []string{"{{.Branch}}"}, string(ghPayload), "MASTER\n"},
+		{`echo "$GITHOOK_BRANCH $#" 1>&2; echo "$0"`, nil, string(ghPayload), "githook\n"},
+		{`echo "branch=$1"`, []string{"{{.Branch}}"}, maliciousPayload, "branch=master;echo INJECTED$(echo INJECTED)\n"},
+		{`echo "branch=$GITHOOK_BRANCH"`, nil, maliciousPayload, "branch=master;echo INJECTED$(echo INJECTED)\n"},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        "github",
+			Script:      test.Script,
+			Cmd:         test.Cmd,
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+		}
+
+		req, err := http.NewRequest("POST", "github?sync", strings.NewReader(test.Payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+		go CommandWorker("TestRepoRequestHandlerScript", workerChannel, cmdLog, nil)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		close(workerChannel)
+
+		var jsonBody struct {
+			Status int
+			Body   CommandResult
+		}
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+			continue
+		}
+
+		if rr.Code != http.StatusOK || string(jsonBody.Body.Stdout) != test.Expected {
+			t.Errorf("%02d. Script %s returned %v with stdout %q, expected %q", i, test.Script, rr.Code, jsonBody.Body.Stdout, test.Expected)
+		}
+		if jsonBody.Body.Cmd[0] != scriptShell || jsonBody.Body.Cmd[2] != test.Script {
+			t.Errorf("%02d. Script must be run with %s, got %v", i, scriptShell, jsonBody.Body.Cmd)
+		}
+	}
+}
+
+func TestRepoRequestHandlerSteps(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Steps    []Step
+		Executed []string
+		Err      bool
+	}{
+		{
+			[]Step{{Name: "fetch", Cmd: []string{"echo", "{{.Branch}}"}}, {Name: "build", Script: "echo $1 | tr a-z A-Z", Cmd: []string{"{{.Branch}}"}}},
+			[]string{"fetch", "build"},
+			false,
+		},
+		{
+			[]Step{{Name: "fetch", Cmd: []string{"false"}}, {Name: "build", Cmd: []string{"true"}}},
+			[]string{"fetch"},
+			true,
+		},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{
+			Type:        "github",
+			Steps:       test.Steps,
+			Path:        "/payloadtest",
+			Timeout:     10,
+			Concurrency: 1,
+		}
+
+		req, err := http.NewRequest("POST", "github?sync", bytes.NewReader(ghPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		cmdLog := NewMemoryCommandLog(100)
+		workerChannel := make(chan CommandJob, 100)
+		go CommandWorker("TestRepoRequestHandlerSteps", workerChannel, cmdLog, nil)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, nil, "test", hook))
+
+		ctx := context.WithValue(req.Context(), "requestID", "my-request-id")
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		close(workerChannel)
+
+		var jsonBody struct {
+			Status int
+			Body   CommandResult
+		}
+		err = json.Unmarshal([]byte(rr.Body.String()), &jsonBody)
+		if err != nil {
+			t.Errorf("%02d. Unable to decode JSON body into a Response: %s", i, err)
+			continue
+		}
+
+		var executed []string
+		for _, step := range jsonBody.Body.Steps {
+			executed = append(executed, step.Step)
+		}
+		if strings.Join(executed, ",") != strings.Join(test.Executed, ",") {
+			t.Errorf("%02d. Handler executed steps %v, expected %v", i, executed, test.Executed)
+		}
+		if test.Err != (jsonBody.Body.Err != "") {
+			t.Errorf("%02d. Job error must be set only when a step fails, got %v", i, jsonBody.Body.Err)
+		}
+		if len(jsonBody.Body.Steps) > 1 && string(jsonBody.Body.Steps[1].Stdout) != "MASTER\n" {
+			t.Errorf("%02d. Build step stdout must be MASTER, got %q", i, jsonBody.Body.Steps[1].Stdout)
+		}
+	}
+}
+
+func TestJobsHandler(t *testing.T) {
+	cmdLog := NewMemoryCommandLog(100)
+	cmdLog.AppendResult(CommandResult{ID: "finished-job", Cmd: []string{"true"}, Stdout: []byte("stored output")})
+	cmdLog.AppendResult(CommandResult{ID: "rejected-job", Rejected: "Invalid signature"})
+	jobs := NewJobStore(10)
+	workerChannel := make(chan CommandJob, 1)
+	defer close(workerChannel)
+	go CommandWorker("TestJobsHandler", workerChannel, cmdLog, jobs)
+	workerChannel <- CommandJob{ID: "running-job", Timeout: 10, Cmd: []string{"sh", "-c", "echo one; echo two >&2; sleep 1; printf 'three\\nfour'; exit 3"}}
+	for i := 0; i < 100; i++ {
+		if _, running := jobs.Stream("running-job"); running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	testCases := []struct {
+		path     string
+		status   int
+		expected []string
+	}{
+		{
+			"/admin/jobs/running-job/stream",
+			200,
+			[]string{
+				// stdout and stderr are read concurrently, their chunks may arrive in any order
+				"event: output\ndata: ",
+				"data: one\n",
+				"data: two\n",
+				"event: output\ndata: three\ndata: four\n\n",
+				"event: status\ndata: {\"id\":\"running-job\",\"hook\":\"TestJobsHandler\",",
+				"\"exit_code\":3,",
+			},
+		},
+		{"/admin/jobs/finished-job/stream", 200, []string{"event: status\ndata: {\"id\":\"finished-job\",", "\"stdout\":null"}},
+		{"/admin/jobs/rejected-job/stream", 404, []string{"Job rejected-job not found"}},
+		{"/admin/jobs/unknown-job/stream", 404, []string{"Job unknown-job not found"}},
+		{"/admin/jobs/running-job/other", 404, []string{"Not found"}},
+		{"/admin/jobs//stream", 404, []string{"Not found"}},
+		{"/admin/jobs/running-job/other/stream", 404, []string{"Not found"}},
+	}
+
+	for i, test := range testCases {
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(JobsHandler(jobs, cmdLog))
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%02d. Handler returned wrong status code: got %v want %v", i, rr.Code, test.status)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("%02d. Handler response must contain %q, got %q", i, expected, rr.Body.String())
+			}
+		}
+		if test.status == 200 && rr.Header().Get("Content-Type") != "text/event-stream" {
+			t.Errorf("%02d. Handler must return an event stream, got %s", i, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestRepoRequestHandlerJobs(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := Hook{Type: "github", Cmd: []string{"echo", "{{.Branch}}"}, Path: "/payloadtest", Timeout: 10, Concurrency: 1}
+	cmdLog := NewMemoryCommandLog(100)
+	jobs := NewJobStore(100)
+	workerChannel := make(chan CommandJob, 100)
+	handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, jobs, "test", hook))
+	jobsHandler := http.HandlerFunc(JobsHandler(jobs, cmdLog))
+
+	request := func(handler http.Handler, method, path, requestID string, body []byte) (rr *httptest.ResponseRecorder, response Response) {
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), "requestID", requestID)))
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return
+	}
+	state := func(response Response) string {
+		if body, ok := response.Body.(map[string]interface{}); ok {
+			return fmt.Sprintf("%v", body["state"])
+		}
+		return ""
+	}
+
+	for _, id := range []string{"job-1", "job-2"} {
+		rr, response := request(handler, "POST", "github", id, ghPayload)
+		if rr.Code != http.StatusAccepted || rr.Header().Get("Location") != "/admin/jobs/"+id || state(response) != JobQueued {
+			t.Errorf("Handler must accept %s returning its status URL, got %v %q %v", id, rr.Code, rr.Header().Get("Location"), response)
+		}
+	}
+
+	if rr, response := request(jobsHandler, "DELETE", "/admin/jobs/job-2", "", nil); rr.Code != http.StatusOK || state(response) != JobCancelled {
+		t.Errorf("Queued jobs must be cancelled, got %v %v", rr.Code, response)
+	}
+	if rr, _ := request(jobsHandler, "DELETE", "/admin/jobs/unknown", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown jobs cannot be cancelled, got %v", rr.Code)
+	}
+	if rr, _ := request(jobsHandler, "PUT", "/admin/jobs/job-1", "", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Jobs API must only allow GET and DELETE, got %v", rr.Code)
+	}
+
+	go CommandWorker("test", workerChannel, cmdLog, jobs)
+	defer close(workerChannel)
+	for i := 0; i < 100; i++ {
+		if _, response := request(jobsHandler, "GET", "/admin/jobs/job-2", "", nil); state(response) == JobCancelled {
+			if status, _ := jobs.Get("job-2"); status.Result != nil {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rr, response := request(jobsHandler, "GET", "/admin/jobs/job-1", "", nil)
+	if rr.Code != http.StatusOK || state(response) != JobSucceeded {
+		t.Errorf("Job must be finished successfully, got %v %v", rr.Code, response)
+	}
+	if rr, _ := request(jobsHandler, "DELETE", "/admin/jobs/job-1", "", nil); rr.Code != http.StatusConflict {
+		t.Errorf("Finished jobs cannot be cancelled, got %v", rr.Code)
+	}
+	results, _ := cmdLog.GetResults(-1)
+	if len(results) != 2 || !results[0].Cancelled || results[1].Cancelled || string(results[1].Stdout) != "master\n" {
+		t.Errorf("Cancelled jobs must be recorded without running, got %#v", results)
+	}
+
+	cmdLog.AppendResult(CommandResult{ID: "stored-job", Hook: "test", Err: "Command timed out after 1 seconds", TimedOut: true})
+	if rr, response := request(jobsHandler, "GET", "/admin/jobs/stored-job", "", nil); rr.Code != http.StatusOK || state(response) != JobTimedOut {
+		t.Errorf("Jobs must be looked for at the command log, got %v %v", rr.Code, response)
+	}
+	if rr, _ := request(jobsHandler, "GET", "/admin/jobs/unknown", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Unknown jobs must not be found, got %v", rr.Code)
+	}
+}
+
+func TestRepoRequestHandlerOverflow(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Overflow string
+		Status   int
+		Dropped  bool
+	}{
+		{"", http.StatusServiceUnavailable, false},
+		{OverflowReject, http.StatusServiceUnavailable, false},
+		{OverflowBlock, http.StatusServiceUnavailable, false},
+		{OverflowDropOldest, http.StatusAccepted, true},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{Type: "github", Cmd: []string{"true"}, Path: "/payloadtest", Timeout: 30, Overflow: test.Overflow, OverflowTimeout: 1}
+		cmdLog := NewMemoryCommandLog(100)
+		jobs := NewJobStore(100)
+		workerChannel := make(chan CommandJob, 1)
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, jobs, "test", hook))
+
+		var responses []Response
+		var recorders []*httptest.ResponseRecorder
+		for _, id := range []string{"job-1", "job-2"} {
+			req, _ := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), "requestID", id)))
+			var response Response
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			responses, recorders = append(responses, response), append(recorders, rr)
+		}
+
+		if recorders[0].Code != http.StatusAccepted || responses[0].Queue == nil || *responses[0].Queue != (QueueStatus{Depth: 1, Capacity: 1}) {
+			t.Errorf("%02d. First request must be queued reporting the queue status, got %v %#v", i, recorders[0].Code, responses[0])
+		}
+		if recorders[1].Code != test.Status || responses[1].Status != test.Status || responses[1].Queue == nil {
+			t.Errorf("%02d. Second request returned %v, expected %v", i, recorders[1].Code, test.Status)
+		}
+		if retryAfter := recorders[1].Header().Get("Retry-After"); test.Status == http.StatusServiceUnavailable && retryAfter != "1" {
+			t.Errorf("%02d. Rejected requests must set Retry-After to the hook overflow timeout, got %q", i, retryAfter)
+		}
+		first, _ := jobs.Get("job-1")
+		second, found := jobs.Get("job-2")
+		stats := jobs.Stats()["test"]
+		if test.Dropped {
+			results, _ := cmdLog.GetResults(-1)
+			if first.State != JobCancelled || second.State != JobQueued || stats.Dropped != 1 || len(results) != 1 || !results[0].Cancelled {
+				t.Errorf("%02d. Oldest job must be dropped, got %#v %#v %#v", i, first, second, results)
+			}
+		} else if first.State != JobQueued || found || stats.Rejected != 1 || stats.Queued != 1 {
+			t.Errorf("%02d. Second job must be rejected, got %#v %#v %#v", i, first, second, stats)
+		}
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/metrics", nil)
+		http.HandlerFunc(MetricsHandler(map[string]chan CommandJob{"test": workerChannel, "idle": make(chan CommandJob, 5)}, jobs)).ServeHTTP(rr, req)
+		var metrics struct {
+			Body map[string]HookMetrics
+		}
+		json.Unmarshal(rr.Body.Bytes(), &metrics)
+		if metrics.Body["test"].Queue.Depth != 1 || metrics.Body["test"].Queued != 2-stats.Rejected || metrics.Body["idle"].Queue.Capacity != 5 {
+			t.Errorf("%02d. Metrics do not match the hook queues, got %#v", i, metrics.Body)
+		}
+	}
+}
+
+func TestRepoRequestHandlerCoalesce(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Coalesce bool
+		Debounce int
+		Queued   int
+		States   []string
+	}{
+		{false, 0, 3, []string{JobQueued, JobQueued, JobQueued}},
+		{true, 0, 3, []string{JobCancelled, JobCancelled, JobQueued}},
+		{false, 1, 0, []string{JobCancelled, JobCancelled, JobQueued}},
+	}
+
+	for i, test := range testCases {
+		hook := Hook{Type: "github", Cmd: []string{"true"}, Path: "/payloadtest", Coalesce: test.Coalesce, Debounce: test.Debounce}
+		cmdLog := NewMemoryCommandLog(100)
+		jobs := NewJobStore(100)
+		workerChannel := make(chan CommandJob, 5)
+		handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, jobs, "test", hook))
+
+		ids := []string{"job-1", "job-2", "job-3"}
+		for _, id := range ids {
+			req, _ := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), "requestID", id)))
+			if rr.Code != http.StatusAccepted {
+				t.Errorf("%02d. Request %s returned %v, expected %v", i, id, rr.Code, http.StatusAccepted)
+			}
+		}
+
+		if len(workerChannel) != test.Queued {
+			t.Errorf("%02d. Hook queue must hold %d jobs, got %d", i, test.Queued, len(workerChannel))
+		}
+		for j, id := range ids {
+			status, _ := jobs.Get(id)
+			if status.State != test.States[j] {
+				t.Errorf("%02d. Job %s must be %s, got %#v", i, id, test.States[j], status)
+			} else if status.State != JobCancelled {
+				continue
+			}
+			if reason := "Superseded by job " + ids[j+1]; status.Reason != reason && (status.Result == nil || status.Result.Err != "Job cancelled: "+reason) {
+				t.Errorf("%02d. Job %s must be superseded by the next one, got %#v", i, id, status)
+			}
+		}
+		if test.Debounce > 0 {
+			results, _ := cmdLog.GetResults(-1)
+			if len(results) != 2 || !results[0].Cancelled || !results[1].Cancelled {
+				t.Errorf("%02d. Debounced jobs must be recorded as cancelled, got %#v", i, results)
+			}
+			select {
+			case job := <-workerChannel:
+				if job.ID != "job-3" {
+					t.Errorf("%02d. Only the last debounced job must be queued, got %s", i, job.ID)
+				}
+			case <-time.After(time.Duration(test.Debounce)*time.Second + time.Second):
+				t.Errorf("%02d. Debounced job must be queued after %d seconds", i, test.Debounce)
+			}
+		}
+	}
+}
+
+func TestRepoRequestHandlerCoalesceFullQueue(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Hook{Type: "github", Cmd: []string{"true"}, Path: "/payloadtest", Timeout: 30, Coalesce: true}
+	cmdLog := NewMemoryCommandLog(100)
+	jobs := NewJobStore(100)
+	workerChannel := make(chan CommandJob, 2)
+	handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, jobs, "test", hook))
+	request := func(id string) int {
+		req, _ := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), "requestID", id)))
+		return rr.Code
+	}
+
+	// Superseded jobs must not take room from newer ones
+	other := CommandJob{ID: "other-job"}
+	jobs.Queue("test", other)
+	workerChannel <- other
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		if code := request(id); code != http.StatusAccepted {
+			t.Errorf("Request %s returned %v, expected %v", id, code, http.StatusAccepted)
+		}
+	}
+	if len(workerChannel) != 2 || (<-workerChannel).ID != "other-job" || (<-workerChannel).ID != "job-3" {
+		t.Errorf("Superseded jobs must be removed from the hook queue, got %d queued jobs", len(workerChannel))
+	}
+	for _, id := range []string{"job-1", "job-2"} {
+		if status, _ := jobs.Get(id); status.State != JobCancelled || status.Result == nil || status.Result.Superseded == "" {
+			t.Errorf("Job %s must be recorded as superseded, got %#v", id, status)
+		}
+	}
+	if results, _ := cmdLog.GetResults(-1); len(results) != 2 {
+		t.Errorf("Removed jobs must be stored at the command log, got %#v", results)
+	}
+	if stats := jobs.Stats()["test"]; stats.Rejected != 0 || stats.Dropped != 0 || stats.Finished[JobCancelled] != 2 {
+		t.Errorf("Removed jobs must be counted as cancelled, got %#v", stats)
+	}
+}
+
+func TestRepoRequestHandlerDebounceFullQueue(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Hook{Type: "github", Cmd: []string{"true"}, Path: "/payloadtest", Timeout: 30, Debounce: 1, DedupeWindow: 60}
+	cmdLog := NewMemoryCommandLog(100)
+	jobs := NewJobStore(100)
+	workerChannel := make(chan CommandJob, 1)
+	workerChannel <- CommandJob{ID: "other-job"}
+	handler := http.HandlerFunc(RepoRequestHandler(cmdLog, workerChannel, jobs, "test", hook))
+	request := func(id string) int {
+		req, _ := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Delivery", "delivery-1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), "requestID", id)))
+		return rr.Code
+	}
+
+	if code := request("job-1"); code != http.StatusAccepted {
+		t.Fatalf("Debounced request returned %v, expected %v", code, http.StatusAccepted)
+	}
+	// The rejected job is stored at the command log once it is counted and its delivery released
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if count, _ := cmdLog.Count(); count > 0 {
+			break
+		}
+	}
+
+	stats := jobs.Stats()["test"]
+	if stats.Rejected != 1 || stats.Dropped != 0 || stats.Queued != 0 {
+		t.Errorf("Debounced job which cannot be queued must be counted as rejected, got %#v", stats)
+	}
+	if results, _ := cmdLog.GetResults(-1); len(results) != 1 || results[0].ID != "job-1" || results[0].Rejected == "" {
+		t.Errorf("Debounced job which cannot be queued must be stored as rejected, got %#v", results)
+	}
+	<-workerChannel
+	if code := request("job-2"); code != http.StatusAccepted {
+		t.Errorf("Redelivery of a rejected debounced job returned %v, expected %v", code, http.StatusAccepted)
+	}
+}
+
+func TestRepoRequestHandlerCancelInProgress(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, cancelInProgress := range []bool{false, true} {
+		hook := Hook{Type: "github", Cmd: []string{"true"}, Path: "/payloadtest", Coalesce: true, CancelInProgress: cancelInProgress}
+		jobs := NewJobStore(100)
+		workerChannel := make(chan CommandJob, 5)
+		handler := http.HandlerFunc(RepoRequestHandler(NewMemoryCommandLog(100), workerChannel, jobs, "test", hook))
+		request := func(id string) {
+			req, _ := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+			req.Header.Set("Content-Type", "application/json")
+			handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(context.WithValue(req.Context(), "requestID", id)))
+		}
+
+		request("job-1")
+		_, cancel, _ := jobs.Start("test", <-workerChannel)
+		request("job-2")
+
+		status, _ := jobs.Get("job-1")
+		select {
+		case <-cancel:
+			if !cancelInProgress || status.SupersededBy != "job-2" {
+				t.Errorf("%02d. Running job must only be superseded with cancel_in_progress, got %#v", i, status)
+			}
+		default:
+			if cancelInProgress || status.SupersededBy != "" {
+				t.Errorf("%02d. Running job must be superseded with cancel_in_progress, got %#v", i, status)
+			}
+		}
+		if status, _ := jobs.Get("job-2"); status.State != JobQueued {
+			t.Errorf("%02d. Newer job must be queued, got %#v", i, status)
+		}
+	}
+}
+
+func TestRepoRequestHandlerDedupe(t *testing.T) {
+	ghPayload, err := ioutil.ReadFile("../payloads/github.com.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := Hook{Type: "github", Cmd: []string{"true"}, Path: "/payloadtest", Timeout: 30, DedupeWindow: 60}
+	jobs := NewJobStore(100)
+	workerChannel := make(chan CommandJob, 2)
+	handler := http.HandlerFunc(RepoRequestHandler(NewMemoryCommandLog(100), workerChannel, jobs, "test", hook))
+
+	testCases := []struct {
+		ID       string
+		Delivery string
+		Status   int
+		JobID    string
+	}{
+		{"job-1", "delivery-1", http.StatusAccepted, "job-1"},
+		{"job-2", "delivery-1", http.StatusOK, "job-1"},
+		{"job-3", "delivery-2", http.StatusAccepted, "job-3"},
+		// Rejected deliveries are not remembered, so they can be retried
+		{"job-4", "delivery-3", http.StatusServiceUnavailable, ""},
+		{"job-5", "delivery-3", http.StatusServiceUnavailable, ""},
+	}
+
+	for i, test := range testCases {
+		req, _ := http.NewRequest("POST", "github", bytes.NewReader(ghPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Delivery", test.Delivery)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), "requestID", test.ID)))
+		var response struct {
+			Body JobStatus
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != test.Status {
+			t.Errorf("%02d. Request %s returned %v, expected %v", i, test.ID, rr.Code, test.Status)
+		}
+		if response.Body.ID != test.JobID {
+			t.Errorf("%02d. Request %s must point to job %q, got %#v", i, test.ID, test.JobID, response.Body)
+		}
+		if location := rr.Header().Get("Location"); test.JobID != "" && location != "/admin/jobs/"+test.JobID {
+			t.Errorf("%02d. Request %s Location must point to job %s, got %s", i, test.ID, test.JobID, location)
+		}
+	}
+	if _, found := jobs.Get("job-2"); found || len(workerChannel) != 2 {
+		t.Errorf("Duplicate deliveries must not queue any job, got %d queued jobs", len(workerChannel))
+	}
+}
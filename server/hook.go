@@ -1,5 +1,17 @@
 package server
 
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Wiston999/githook/event"
+)
+
 // Hook structure holds all the information needed to configure an HTTP endpoint
 // and execute the custom command on the system
 // Type refers to the repository provider, it can be github, bitbucket or gitlab
@@ -18,10 +30,354 @@ package server
 // but will be treated as part of a shell-command parameter
 // Concurrency determines the number of concurrent workers that will be available to run command
 // a concurrency level of 1 means that only 1 command can be executed at a time (mutex mode), default is 1
+// Secret is the shared secret used to validate the requests' signature, when it is empty no validation
+// is performed. It can also be read from an environment variable (SecretEnv) or from a file (SecretFile)
+// Options holds the settings for parsers that need per hook configuration, like the generic one
+// Filters holds the conditions an event must match to execute Cmd, see Filters
+// Env holds extra environment variables for Cmd, values are templates like Cmd elements. They are added
+// to the githook environment and the GITHOOK_* variables describing the event (see EventEnv)
+// Stdin enables writing the raw request payload to Cmd standard input
+// Workdir is the Cmd working directory, it is a template like Cmd elements and it is created when
+// CreateWorkdir is set. User, Group and Umask set the user, group and file mode creation mask Cmd
+// runs with, see CommandOptions
+// Script is a shell script run with /bin/sh -c instead of Cmd. It is not a template, the translated Cmd
+// elements are passed to it as positional parameters ($1, $2...) and the event is available at the
+// GITHOOK_* environment variables, so remote data is never interpreted as part of the script
+// Steps holds an ordered list of commands run instead of Cmd (or Script), each one with its own result
+// at the command log, see Step. Env, Stdin, Workdir, User, Group and Umask apply to every step
+// KillGrace is the number of seconds a command is given to terminate, once Timeout expires, before
+// being killed, it defaults to 5 seconds when it is not set and 0 kills the command right away
+// MaxOutput is the number of bytes of each command output stream stored at the command log, it defaults
+// to 1MiB. When OutputDir is set, the whole output of every job is written to a file in that directory
+// Overflow is the policy applied when the hook queue is full: reject (default) answers 503 with a Retry-After
+// header, drop_oldest cancels the oldest queued jobs to make room and block waits up to OverflowTimeout
+// seconds (default 10) for room before rejecting the request. Rejected requests are asked to retry after
+// OverflowTimeout seconds
+// Coalesce replaces a queued job by the newer event with the same CoalesceKey, a template defaulting to
+// {{.Branch}}, so only the last one runs. Debounce is the number of seconds jobs wait for no newer event
+// with the same key before being queued, it implies Coalesce. CancelInProgress also stops the running job with
+// the same key, as it is stopped on timeout, so the newer event does not wait for it; it implies Coalesce
+// DedupeWindow is the number of seconds delivery IDs are remembered, redelivered webhooks received meanwhile
+// do not run any job. It is disabled by default
 type Hook struct {
-	Type        string
-	Path        string
-	Timeout     int
-	Cmd         []string
-	Concurrency int
+	Type             string
+	Path             string
+	Timeout          int
+	Cmd              []string
+	Concurrency      int
+	Secret           string
+	SecretEnv        string `yaml:"secret_env"`
+	SecretFile       string `yaml:"secret_file"`
+	Options          map[string]string
+	Filters          Filters
+	Env              map[string]string
+	Stdin            bool
+	Workdir          string
+	CreateWorkdir    bool `yaml:"create_workdir"`
+	User             string
+	Group            string
+	Umask            string
+	Script           string
+	Steps            []Step
+	KillGrace        *int   `yaml:"kill_grace"`
+	MaxOutput        int    `yaml:"max_output"`
+	OutputDir        string `yaml:"output_dir"`
+	Overflow         string
+	OverflowTimeout  int `yaml:"overflow_timeout"`
+	Coalesce         bool
+	CoalesceKey      string `yaml:"coalesce_key"`
+	Debounce         int
+	CancelInProgress bool `yaml:"cancel_in_progress"`
+	DedupeWindow     int  `yaml:"dedupe_window"`
+}
+
+// Step holds one command of a hook pipeline, see Hook.Steps
+// Name identifies the step in the command log, it defaults to step-N
+// Cmd, Script and Env work like the Hook ones, Timeout defaults to the Hook Timeout
+// ContinueOnError allows running the next steps when this one fails
+type Step struct {
+	Name            string
+	Cmd             []string
+	Script          string
+	Timeout         int
+	Env             map[string]string
+	ContinueOnError bool `yaml:"continue_on_error"`
+}
+
+// scriptShell is the shell used to run hook scripts
+const scriptShell = "/bin/sh"
+
+// scriptCommand returns the command line executed given a script and the translated Cmd elements at args.
+// When script is set, the shell is invoked with the script and args as positional parameters, being
+// $0 set to githook, args are returned as is otherwise
+func scriptCommand(script string, args []string) []string {
+	if script == "" {
+		return args
+	}
+	return append([]string{scriptShell, "-c", script, "githook"}, args...)
+}
+
+// validateSteps checks the hook Steps, which cannot be combined with Cmd or Script
+// It returns error if any step has no command or its templates are not valid
+func (h Hook) validateSteps() (err error) {
+	if len(h.Steps) > 0 && (len(h.Cmd) > 0 || h.Script != "") {
+		return errors.New("Steps cannot be defined along with cmd or script")
+	}
+	for i, step := range h.Steps {
+		if len(step.Cmd) == 0 && step.Script == "" {
+			return fmt.Errorf("Step %d must define cmd or script", i+1)
+		}
+		if step.Timeout < 0 {
+			return fmt.Errorf("Step %d timeout must be greater than 0, got %d", i+1, step.Timeout)
+		}
+		if _, err = parseTemplates(step.Cmd); err != nil {
+			return fmt.Errorf("Invalid step %d cmd template: %s", i+1, err)
+		}
+		if err = validateEnv(step.Env); err != nil {
+			return fmt.Errorf("Invalid step %d env: %s", i+1, err)
+		}
+	}
+	return
+}
+
+// scripts returns the hook Script and the steps ones
+func (h Hook) scripts() (scripts []string) {
+	if h.Script != "" {
+		scripts = append(scripts, h.Script)
+	}
+	for _, step := range h.Steps {
+		if step.Script != "" {
+			scripts = append(scripts, step.Script)
+		}
+	}
+	return
+}
+
+// job builds the CommandJob executed when the hook hookName receives the event e at the request
+// requestID, body is the raw request payload
+// It returns error if any of the hook templates cannot be translated
+func (h Hook) job(hookName, requestID string, e event.RepoEvent, body []byte) (job CommandJob, err error) {
+	job = CommandJob{ID: requestID, Timeout: h.Timeout, Event: &e, QueuedAt: time.Now()}
+
+	env, err := TranslateEnv(h.Env, e)
+	if err != nil {
+		return job, fmt.Errorf("Unable to translate environment template: %s", err)
+	}
+	job.Options = CommandOptions{
+		Env:       append(EventEnv(hookName, requestID, e), env...),
+		CreateDir: h.CreateWorkdir,
+		User:      h.User,
+		Group:     h.Group,
+		Umask:     h.Umask,
+		KillGrace: defaultKillGrace,
+		MaxOutput: h.MaxOutput,
+	}
+	if h.KillGrace != nil {
+		job.Options.KillGrace = *h.KillGrace
+	}
+	if job.Options.MaxOutput == 0 {
+		job.Options.MaxOutput = defaultMaxOutput
+	}
+	if h.OutputDir != "" {
+		job.Options.OutputFile = filepath.Join(h.OutputDir, hookName+"-"+requestID+".log")
+	}
+	if h.Stdin {
+		job.Options.Stdin = body
+	}
+	if h.Workdir != "" {
+		workdir, translateErr := TranslateParams([]string{h.Workdir}, e)
+		if translateErr != nil {
+			return job, fmt.Errorf("Unable to translate workdir template: %s", translateErr)
+		}
+		if err = validateWorkdir(workdir[0]); err != nil {
+			return job, err
+		}
+		job.Options.Dir = workdir[0]
+	}
+
+	if len(h.Steps) == 0 {
+		args, translateErr := TranslateParams(h.Cmd, e)
+		if translateErr != nil {
+			return job, fmt.Errorf("Unable to translate command template: %s", translateErr)
+		}
+		job.Cmd = scriptCommand(h.Script, args)
+		return
+	}
+
+	for i, step := range h.Steps {
+		commandStep := CommandStep{
+			Name:            step.Name,
+			Timeout:         step.Timeout,
+			ContinueOnError: step.ContinueOnError,
+		}
+		if commandStep.Name == "" {
+			commandStep.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if commandStep.Timeout == 0 {
+			commandStep.Timeout = h.Timeout
+		}
+		args, translateErr := TranslateParams(step.Cmd, e)
+		if translateErr != nil {
+			return job, fmt.Errorf("Unable to translate %s command template: %s", commandStep.Name, translateErr)
+		}
+		commandStep.Cmd = scriptCommand(step.Script, args)
+		if commandStep.Env, err = TranslateEnv(step.Env, e); err != nil {
+			return job, fmt.Errorf("Unable to translate %s environment template: %s", commandStep.Name, err)
+		}
+		job.Steps = append(job.Steps, commandStep)
+	}
+	return
+}
+
+// Parser returns the event.Parser registered for the hook Type, configured with the hook Options
+// when the parser implements event.Configurable
+// It returns error if Type is unknown or Options are not valid
+func (h Hook) Parser() (parser event.Parser, err error) {
+	parser, found := event.Lookup(h.Type)
+	if !found {
+		return nil, errors.New("Unknown repository type, it must be one of: " + strings.Join(event.Parsers(), ", "))
+	}
+	if configurable, ok := parser.(event.Configurable); ok {
+		return configurable.Configure(h.Options)
+	}
+	if len(h.Options) > 0 {
+		err = errors.New("Repository type " + h.Type + " does not accept options")
+	}
+	return
+}
+
+// validateOverflow checks the hook Overflow policy and OverflowTimeout
+// It returns error if the policy is unknown or the timeout is negative
+func (h Hook) validateOverflow() (err error) {
+	switch h.Overflow {
+	case "", OverflowReject, OverflowDropOldest, OverflowBlock:
+	default:
+		return fmt.Errorf("Unknown overflow policy %q, it must be one of: %s, %s, %s", h.Overflow, OverflowReject, OverflowDropOldest, OverflowBlock)
+	}
+	if h.OverflowTimeout < 0 {
+		return fmt.Errorf("Overflow timeout must be greater than or equal to 0, got %d", h.OverflowTimeout)
+	}
+	return
+}
+
+// defaultCoalesceKey is the template used to group jobs when Coalesce is set and CoalesceKey is not
+const defaultCoalesceKey = "{{.Branch}}"
+
+// coalesced returns whether jobs are coalesced by key, see Hook.Coalesce
+func (h Hook) coalesced() bool {
+	return h.Coalesce || h.Debounce > 0 || h.CancelInProgress
+}
+
+// coalesceKey returns the key grouping the jobs run for the event e, see Hook.CoalesceKey
+// It returns error if the key template cannot be translated
+func (h Hook) coalesceKey(e event.RepoEvent) (key string, err error) {
+	tpl := h.CoalesceKey
+	if tpl == "" {
+		tpl = defaultCoalesceKey
+	}
+	keys, err := TranslateParams([]string{tpl}, e)
+	if err != nil {
+		return "", fmt.Errorf("Unable to translate coalesce key template: %s", err)
+	}
+	return keys[0], nil
+}
+
+// validateCoalesce checks the hook CoalesceKey template and Debounce
+// It returns error if the template is not valid or Debounce is negative
+func (h Hook) validateCoalesce() (err error) {
+	if _, err = parseTemplates([]string{h.CoalesceKey}); err != nil {
+		return fmt.Errorf("Invalid coalesce key template: %s", err)
+	}
+	if h.Debounce < 0 {
+		return fmt.Errorf("Debounce must be greater than or equal to 0, got %d", h.Debounce)
+	}
+	return
+}
+
+// validateWorkdir checks that the workdir dir, once translated, is an absolute path without .. elements,
+// so event data cannot make commands run (or create directories) anywhere else
+// It returns error if dir is not valid
+func validateWorkdir(dir string) (err error) {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("Workdir %q must be an absolute path", dir)
+	}
+	for _, element := range strings.Split(filepath.ToSlash(dir), "/") {
+		if element == ".." {
+			return fmt.Errorf("Workdir %q must not contain .. elements", dir)
+		}
+	}
+	return
+}
+
+// validateCommandSettings checks that Workdir, User, Group, Umask, MaxOutput and OutputDir can be applied to
+// the hook commands. Workdir must exist unless CreateWorkdir is set or it depends on the event
+// It returns error if any of the settings is not valid
+func (h Hook) validateCommandSettings() (err error) {
+	if h.MaxOutput < 0 {
+		return fmt.Errorf("Max output must be greater than or equal to 0, got %d", h.MaxOutput)
+	}
+	if h.OutputDir != "" {
+		info, statErr := os.Stat(h.OutputDir)
+		if statErr != nil {
+			return statErr
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("Output directory %s is not a directory", h.OutputDir)
+		}
+	}
+	if h.Workdir != "" {
+		if _, err = parseTemplates([]string{h.Workdir}); err != nil {
+			return fmt.Errorf("Invalid workdir template: %s", err)
+		}
+		if !strings.Contains(h.Workdir, "{{") {
+			if err = validateWorkdir(h.Workdir); err != nil {
+				return
+			}
+		}
+		if !h.CreateWorkdir && !strings.Contains(h.Workdir, "{{") {
+			info, statErr := os.Stat(h.Workdir)
+			if statErr != nil {
+				return statErr
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("Workdir %s is not a directory", h.Workdir)
+			}
+		}
+	}
+	return validateProcessOptions(h.User, h.Group, h.Umask)
+}
+
+// LoadSecret returns the hook secret looking, in this order, at Secret, SecretEnv and SecretFile
+// It returns an empty string if no secret is configured and error if the configured source
+// cannot be read or is empty
+func (h Hook) LoadSecret() (secret string, err error) {
+	switch {
+	case h.Secret != "":
+		secret = h.Secret
+	case h.SecretEnv != "":
+		secret = os.Getenv(h.SecretEnv)
+		if secret == "" {
+			err = fmt.Errorf("Environment variable %s is empty or not defined", h.SecretEnv)
+		}
+	case h.SecretFile != "":
+		content, readErr := ioutil.ReadFile(h.SecretFile)
+		if readErr != nil {
+			return "", readErr
+		}
+		secret = strings.TrimRight(string(content), "\r\n")
+		if secret == "" {
+			err = errors.New("Secret file " + h.SecretFile + " is empty")
+		}
+	}
+	return
+}
+
+// String implements fmt.Stringer so secrets are not leaked into the logs
+func (h Hook) String() string {
+	type hook Hook
+	masked := hook(h)
+	if masked.Secret != "" {
+		masked.Secret = "******"
+	}
+	return fmt.Sprintf("%+v", masked)
 }
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Wiston999/githook/event"
+)
+
+func TestLoadSecret(t *testing.T) {
+	secretFile, _ := ioutil.TempFile("", "")
+	defer os.Remove(secretFile.Name())
+	secretFile.WriteString("secret-from-file\n")
+	secretFile.Close()
+
+	emptyFile, _ := ioutil.TempFile("", "")
+	defer os.Remove(emptyFile.Name())
+	emptyFile.Close()
+
+	os.Setenv("GITHOOK_TEST_SECRET", "secret-from-env")
+	defer os.Unsetenv("GITHOOK_TEST_SECRET")
+
+	testCases := []struct {
+		hook     Hook
+		expected string
+		err      bool
+	}{
+		{Hook{}, "", false},
+		{Hook{Secret: "inline-secret"}, "inline-secret", false},
+		{Hook{Secret: "inline-secret", SecretEnv: "GITHOOK_TEST_SECRET"}, "inline-secret", false},
+		{Hook{SecretEnv: "GITHOOK_TEST_SECRET"}, "secret-from-env", false},
+		{Hook{SecretEnv: "GITHOOK_TEST_SECRET_NOT_FOUND"}, "", true},
+		{Hook{SecretFile: secretFile.Name()}, "secret-from-file", false},
+		{Hook{SecretFile: emptyFile.Name()}, "", true},
+		{Hook{SecretFile: "/notfound"}, "", true},
+	}
+
+	for i, test := range testCases {
+		secret, err := test.hook.LoadSecret()
+		if test.err && err == nil {
+			t.Errorf("%02d. LoadSecret should fail with %v", i, test.hook)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. LoadSecret should not fail with %v, got %s", i, test.hook, err)
+		}
+		if secret != test.expected {
+			t.Errorf("%02d. LoadSecret expected %s, got %s", i, test.expected, secret)
+		}
+	}
+}
+
+func TestHookString(t *testing.T) {
+	hook := Hook{Type: "github", Secret: "my-very-secret-value"}
+	if strings.Contains(hook.String(), "my-very-secret-value") {
+		t.Errorf("Hook String representation must not contain the secret, got %s", hook)
+	}
+	if hook.Secret != "my-very-secret-value" {
+		t.Errorf("Hook String must not modify the original secret")
+	}
+}
+
+func TestValidateCommandSettings(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "githook-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+	file, err := ioutil.TempFile(workdir, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	testCases := []struct {
+		hook Hook
+		err  bool
+	}{
+		{Hook{}, false},
+		{Hook{Workdir: workdir}, false},
+		{Hook{Workdir: workdir + "/missing"}, true},
+		{Hook{Workdir: workdir + "/missing", CreateWorkdir: true}, false},
+		{Hook{Workdir: file.Name()}, true},
+		{Hook{Workdir: workdir + "/{{.Branch | slugify}}"}, false},
+		{Hook{Workdir: workdir + "/{{.Branch"}, true},
+		{Hook{Workdir: "relative/workdir", CreateWorkdir: true}, true},
+		{Hook{Workdir: workdir + "/../escape", CreateWorkdir: true}, true},
+		{Hook{Umask: "022"}, false},
+		{Hook{Umask: "rwx"}, true},
+		{Hook{User: "this-user-does-not-exist"}, true},
+		{Hook{MaxOutput: 1024}, false},
+		{Hook{MaxOutput: -1}, true},
+		{Hook{OutputDir: workdir}, false},
+		{Hook{OutputDir: workdir + "/missing"}, true},
+		{Hook{OutputDir: file.Name()}, true},
+	}
+
+	for i, test := range testCases {
+		err := test.hook.validateCommandSettings()
+		if test.err && err == nil {
+			t.Errorf("%02d. validateCommandSettings should fail with %v", i, test.hook)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. validateCommandSettings should not fail with %v, got %s", i, test.hook, err)
+		}
+	}
+}
+
+func TestValidateOverflow(t *testing.T) {
+	testCases := []struct {
+		hook Hook
+		err  bool
+	}{
+		{Hook{}, false},
+		{Hook{Overflow: OverflowReject}, false},
+		{Hook{Overflow: OverflowDropOldest}, false},
+		{Hook{Overflow: OverflowBlock, OverflowTimeout: 30}, false},
+		{Hook{Overflow: "drop_newest"}, true},
+		{Hook{Overflow: OverflowBlock, OverflowTimeout: -1}, true},
+	}
+
+	for i, test := range testCases {
+		err := test.hook.validateOverflow()
+		if test.err && err == nil {
+			t.Errorf("%02d. validateOverflow should fail with %v", i, test.hook)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. validateOverflow should not fail with %v, got %s", i, test.hook, err)
+		}
+	}
+}
+
+func TestValidateCoalesce(t *testing.T) {
+	testCases := []struct {
+		hook Hook
+		err  bool
+	}{
+		{Hook{}, false},
+		{Hook{Coalesce: true}, false},
+		{Hook{Coalesce: true, CoalesceKey: "{{.Branch}}-{{.Author}}"}, false},
+		{Hook{Coalesce: true, CoalesceKey: "{{.Branch"}, true},
+		{Hook{Debounce: 30}, false},
+		{Hook{Debounce: -1}, true},
+	}
+
+	for i, test := range testCases {
+		err := test.hook.validateCoalesce()
+		if test.err && err == nil {
+			t.Errorf("%02d. validateCoalesce should fail with %v", i, test.hook)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. validateCoalesce should not fail with %v, got %s", i, test.hook, err)
+		}
+	}
+}
+
+func TestHookCoalesceKey(t *testing.T) {
+	repoEvent := event.RepoEvent{Branch: "feature/foo", Author: "octocat"}
+	testCases := []struct {
+		hook     Hook
+		expected string
+		err      bool
+	}{
+		{Hook{Coalesce: true}, "feature/foo", false},
+		{Hook{Coalesce: true, CoalesceKey: "{{.Branch | slugify}}-{{.Author}}"}, "feature-foo-octocat", false},
+		{Hook{Coalesce: true, CoalesceKey: "{{.Unknown}}"}, "", true},
+	}
+
+	for i, test := range testCases {
+		key, err := test.hook.coalesceKey(repoEvent)
+		if test.err && err == nil {
+			t.Errorf("%02d. coalesceKey should fail with %v", i, test.hook)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. coalesceKey should not fail with %v, got %s", i, test.hook, err)
+		}
+		if key != test.expected {
+			t.Errorf("%02d. coalesceKey expected %s, got %s", i, test.expected, key)
+		}
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	testCases := []struct {
+		script   string
+		args     []string
+		expected []string
+	}{
+		{"", []string{"echo", "master"}, []string{"echo", "master"}},
+		{`echo "$1" | tr a-z A-Z`, []string{"master"}, []string{"/bin/sh", "-c", `echo "$1" | tr a-z A-Z`, "githook", "master"}},
+		{"make deploy > /tmp/deploy.log", nil, []string{"/bin/sh", "-c", "make deploy > /tmp/deploy.log", "githook"}},
+	}
+
+	for i, test := range testCases {
+		got := scriptCommand(test.script, test.args)
+		if strings.Join(got, "\x00") != strings.Join(test.expected, "\x00") {
+			t.Errorf("%02d. scriptCommand returned %q, expected %q", i, got, test.expected)
+		}
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	testCases := []struct {
+		hook Hook
+		err  bool
+	}{
+		{Hook{Cmd: []string{"true"}}, false},
+		{Hook{Steps: []Step{{Cmd: []string{"git", "fetch"}}, {Script: "make | tee build.log"}}}, false},
+		{Hook{Cmd: []string{"true"}, Steps: []Step{{Cmd: []string{"true"}}}}, true},
+		{Hook{Script: "true", Steps: []Step{{Cmd: []string{"true"}}}}, true},
+		{Hook{Steps: []Step{{Name: "empty"}}}, true},
+		{Hook{Steps: []Step{{Cmd: []string{"true"}, Timeout: -1}}}, true},
+		{Hook{Steps: []Step{{Cmd: []string{"{{.Branch"}}}}, true},
+		{Hook{Steps: []Step{{Cmd: []string{"true"}, Env: map[string]string{"A=B": "value"}}}}, true},
+	}
+
+	for i, test := range testCases {
+		err := test.hook.validateSteps()
+		if test.err && err == nil {
+			t.Errorf("%02d. validateSteps should fail with %v", i, test.hook)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. validateSteps should not fail with %v, got %s", i, test.hook, err)
+		}
+	}
+}
+
+func TestHookJob(t *testing.T) {
+	repoEvent := event.RepoEvent{Branch: "feature/foo", Commit: "0123456789abcdef"}
+	hook := Hook{
+		Timeout: 30,
+		Env:     map[string]string{"BRANCH": "{{.Branch}}"},
+		Workdir: "/tmp/{{.Branch | slugify}}",
+		Stdin:   true,
+		Steps: []Step{
+			{Name: "fetch", Cmd: []string{"git", "fetch", "origin", "{{.Branch}}"}, Timeout: 60},
+			{Script: `make build TAG="$1"`, Cmd: []string{"{{.Commit | shortSha}}"}, Env: map[string]string{"STEP": "build"}, ContinueOnError: true},
+		},
+	}
+
+	job, err := hook.job("my-hook", "my-request-id", repoEvent, []byte("payload"))
+	if err != nil {
+		t.Fatal("Hook job should not fail, got", err)
+	}
+	if job.ID != "my-request-id" || job.Options.Dir != "/tmp/feature-foo" || string(job.Options.Stdin) != "payload" {
+		t.Errorf("Hook job options do not match the hook settings, got %#v", job)
+	}
+	if job.Options.KillGrace != defaultKillGrace || job.Options.MaxOutput != defaultMaxOutput || job.Options.OutputFile != "" {
+		t.Errorf("Hook job kill grace and output settings should use the defaults, got %#v", job.Options)
+	}
+	if !strings.Contains(strings.Join(job.Options.Env, "\n"), "BRANCH=feature/foo") {
+		t.Errorf("Hook job environment must contain the hook env, got %v", job.Options.Env)
+	}
+	if len(job.Steps) != 2 {
+		t.Fatalf("Hook job must have 2 steps, got %d", len(job.Steps))
+	}
+	if step := job.Steps[0]; step.Name != "fetch" || step.Timeout != 60 || strings.Join(step.Cmd, " ") != "git fetch origin feature/foo" {
+		t.Errorf("First step does not match, got %#v", step)
+	}
+	if step := job.Steps[1]; step.Name != "step-2" || step.Timeout != 30 || !step.ContinueOnError ||
+		strings.Join(step.Cmd, " ") != `/bin/sh -c make build TAG="$1" githook 0123456` || strings.Join(step.Env, ",") != "STEP=build" {
+		t.Errorf("Second step does not match, got %#v", step)
+	}
+
+	hook = Hook{Cmd: []string{"true"}, KillGrace: intPointer(0), MaxOutput: 100, OutputDir: "/var/log/githook"}
+	if job, _ = hook.job("my-hook", "my-request-id", repoEvent, nil); job.Options.MaxOutput != 100 || job.Options.OutputFile != "/var/log/githook/my-hook-my-request-id.log" {
+		t.Errorf("Hook job output settings do not match the hook settings, got %#v", job.Options)
+	}
+	if job.Options.KillGrace != 0 {
+		t.Errorf("Hook job kill grace should be 0 when it is set to 0, got %d", job.Options.KillGrace)
+	}
+
+	testCases := []Hook{
+		{Cmd: []string{"true"}, Workdir: "/srv/{{.Branch}}/../../etc"},
+		{Cmd: []string{"true"}, Workdir: "{{.Branch}}"},
+		{Cmd: []string{"{{.Unknown}}"}},
+		{Cmd: []string{"true"}, Env: map[string]string{"BROKEN": "{{.Unknown}}"}},
+		{Cmd: []string{"true"}, Workdir: "{{.Unknown}}"},
+		{Steps: []Step{{Cmd: []string{"{{.Unknown}}"}}}},
+		{Steps: []Step{{Cmd: []string{"true"}, Env: map[string]string{"BROKEN": "{{.Unknown}}"}}}},
+	}
+	for i, test := range testCases {
+		if _, err := test.job("my-hook", "my-request-id", repoEvent, nil); err == nil {
+			t.Errorf("%02d. Hook job should fail with %v", i, test)
+		}
+	}
+}
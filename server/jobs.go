@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states, a job is queued until a worker starts running it and it finishes as succeeded,
+// failed, timed_out or cancelled
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobTimedOut  = "timed_out"
+	JobCancelled = "cancelled"
+)
+
+// JobStatus describes the state of a job, Result holds the job result, without its output,
+// once it is finished and Reason why it was cancelled. SupersededBy is the ID of the newer job
+// which cancelled it, see JobStore.Supersede
+type JobStatus struct {
+	ID           string         `json:"id"`
+	Hook         string         `json:"hook"`
+	State        string         `json:"state"`
+	Cmd          string         `json:"cmd"`
+	QueuedAt     time.Time      `json:"queued_at"`
+	Reason       string         `json:"reason,omitempty"`
+	SupersededBy string         `json:"superseded_by,omitempty"`
+	Result       *CommandResult `json:"result,omitempty"`
+}
+
+// jobState returns the state of a job finished with result
+func jobState(result CommandResult) string {
+	switch {
+	case result.Cancelled:
+		return JobCancelled
+	case result.TimedOut:
+		return JobTimedOut
+	case result.Err != "":
+		return JobFailed
+	}
+	return JobSucceeded
+}
+
+// resultStatus returns the JobStatus of a job finished with result
+func resultStatus(result CommandResult) JobStatus {
+	status := withoutOutput(result)
+	return JobStatus{
+		ID:       result.ID,
+		Hook:     result.Hook,
+		State:    jobState(result),
+		Cmd:      strings.Join(result.Cmd, " "),
+		QueuedAt: result.QueuedAt,
+		Result:   &status,
+	}
+}
+
+// HookStats holds the number of jobs received by a hook: Queued jobs, Rejected ones because the hook
+// queue was full, jobs Dropped from the queue to make room for newer ones and Finished jobs by state
+type HookStats struct {
+	Queued   uint64            `json:"queued"`
+	Rejected uint64            `json:"rejected"`
+	Dropped  uint64            `json:"dropped"`
+	Finished map[string]uint64 `json:"finished"`
+}
+
+// storedJob is a JobStore entry, stream is only set while the job is running
+type storedJob struct {
+	status JobStatus
+	stream *JobStream
+	cancel chan struct{}
+}
+
+// JobStore tracks the state of the jobs indexed by job ID, the JobStream of the running ones and
+// the HookStats of every hook
+// Up to limit finished jobs are kept, the oldest ones are removed first. A limit of 0 or below keeps all of them
+// A nil *JobStore does not track any job
+type JobStore struct {
+	mutex    sync.Mutex
+	limit    int
+	jobs     map[string]*storedJob
+	finished []string
+	stats    map[string]*HookStats
+}
+
+// NewJobStore creates an empty JobStore keeping up to limit finished jobs
+func NewJobStore(limit int) *JobStore {
+	return &JobStore{limit: limit, jobs: make(map[string]*storedJob), stats: make(map[string]*HookStats)}
+}
+
+// hookStats returns the HookStats of hookName, the store must be locked
+func (s *JobStore) hookStats(hookName string) *HookStats {
+	stats, found := s.stats[hookName]
+	if !found {
+		stats = &HookStats{Finished: make(map[string]uint64)}
+		s.stats[hookName] = stats
+	}
+	return stats
+}
+
+// Stats returns a copy of the HookStats of every hook indexed by hook name
+func (s *JobStore) Stats() (stats map[string]HookStats) {
+	stats = make(map[string]HookStats)
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for hookName, hookStats := range s.stats {
+		finished := make(map[string]uint64)
+		for state, count := range hookStats.Finished {
+			finished[state] = count
+		}
+		stats[hookName] = HookStats{Queued: hookStats.Queued, Rejected: hookStats.Rejected, Dropped: hookStats.Dropped, Finished: finished}
+	}
+	return
+}
+
+// Reject removes the queued job id, of hookName, which was not enqueued because the hook queue was full
+func (s *JobStore) Reject(hookName, id string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.jobs[id]; found {
+		delete(s.jobs, id)
+		s.hookStats(hookName).Queued--
+	}
+	s.hookStats(hookName).Rejected++
+}
+
+// Drop stores the result of the queued job id, of hookName, dropped from its queue
+func (s *JobStore) Drop(hookName, id string, result CommandResult) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.hookStats(hookName).Dropped++
+	s.mutex.Unlock()
+	s.Finish(id, result)
+}
+
+// Queue registers job, received by the hook hookName, as queued
+// It returns the job status
+func (s *JobStore) Queue(hookName string, job CommandJob) (status JobStatus) {
+	status = JobStatus{ID: job.ID, Hook: hookName, State: JobQueued, Cmd: job.String(), QueuedAt: job.QueuedAt}
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs[job.ID] = &storedJob{status: status}
+	s.hookStats(hookName).Queued++
+	return
+}
+
+// Start sets job, run by the hook hookName, as running and returns its stream and the channel closed
+// when it is superseded while running
+// It returns false if the job was cancelled while it was queued
+func (s *JobStore) Start(hookName string, job CommandJob) (stream *JobStream, cancel <-chan struct{}, run bool) {
+	stream = newJobStream()
+	if s == nil {
+		return stream, nil, true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, found := s.jobs[job.ID]
+	if !found {
+		stored = &storedJob{status: JobStatus{ID: job.ID, Hook: hookName, Cmd: job.String(), QueuedAt: job.QueuedAt}}
+		s.jobs[job.ID] = stored
+	}
+	if stored.status.State == JobCancelled {
+		return nil, nil, false
+	}
+	stored.status.State, stored.stream, stored.cancel = JobRunning, stream, make(chan struct{})
+	return stream, stored.cancel, true
+}
+
+// Finish stores the result of the job id, closing its stream
+func (s *JobStore) Finish(id string, result CommandResult) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, found := s.jobs[id]
+	if !found {
+		return
+	}
+	if stored.stream != nil {
+		stored.stream.finish(result)
+		stored.stream = nil
+	}
+	status := withoutOutput(result)
+	stored.status.State, stored.status.Result = jobState(result), &status
+	s.hookStats(stored.status.Hook).Finished[stored.status.State]++
+	s.finished = append(s.finished, id)
+	for s.limit > 0 && len(s.finished) > s.limit {
+		delete(s.jobs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+// Cancel sets the queued job id as cancelled because of reason, so it is not run
+// It returns the job status and error if the job is not found or it is not queued
+func (s *JobStore) Cancel(id, reason string) (status JobStatus, err error) {
+	if s == nil {
+		return status, fmt.Errorf("Job %s not found", id)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, found := s.jobs[id]
+	if !found {
+		return status, fmt.Errorf("Job %s not found", id)
+	}
+	if stored.status.State != JobQueued {
+		return stored.status, fmt.Errorf("Job %s is %s, only queued jobs can be cancelled", id, stored.status.State)
+	}
+	stored.status.State, stored.status.Reason = JobCancelled, reason
+	return stored.status, nil
+}
+
+// Supersede cancels the job id because the newer job by replaces it, queued jobs are not run and running
+// ones are stopped when running is set
+// It returns the job status and error if the job is not found, it is already superseded or it cannot be cancelled
+func (s *JobStore) Supersede(id, by string, running bool) (status JobStatus, err error) {
+	if s == nil {
+		return status, fmt.Errorf("Job %s not found", id)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, found := s.jobs[id]
+	if !found {
+		return status, fmt.Errorf("Job %s not found", id)
+	}
+	if stored.status.SupersededBy != "" {
+		return stored.status, fmt.Errorf("Job %s is already superseded by job %s", id, stored.status.SupersededBy)
+	}
+	switch {
+	case stored.status.State == JobQueued:
+		stored.status.State = JobCancelled
+	case stored.status.State == JobRunning && running:
+		close(stored.cancel)
+	default:
+		return stored.status, fmt.Errorf("Job %s is %s, it cannot be superseded", id, stored.status.State)
+	}
+	stored.status.Reason, stored.status.SupersededBy = "Superseded by job "+by, by
+	return stored.status, nil
+}
+
+// Get returns the status of the job id
+func (s *JobStore) Get(id string) (status JobStatus, found bool) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, found := s.jobs[id]
+	if found {
+		status = stored.status
+	}
+	return
+}
+
+// Stream returns the stream of the running job id
+func (s *JobStore) Stream(id string) (stream *JobStream, found bool) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stored, ok := s.jobs[id]; ok && stored.stream != nil {
+		return stored.stream, true
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestJobState(t *testing.T) {
+	testCases := []struct {
+		result   CommandResult
+		expected string
+	}{
+		{CommandResult{}, JobSucceeded},
+		{CommandResult{Err: "exit status 1", ExitCode: 1}, JobFailed},
+		{CommandResult{Err: "Command timed out after 1 seconds", TimedOut: true}, JobTimedOut},
+		{CommandResult{Err: "Job cancelled", Cancelled: true}, JobCancelled},
+	}
+
+	for i, test := range testCases {
+		if got := jobState(test.result); got != test.expected {
+			t.Errorf("%02d. jobState should return %s, got %s", i, test.expected, got)
+		}
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	store := NewJobStore(2)
+	job := CommandJob{ID: "job-1", Cmd: []string{"echo", "hello"}}
+	if status := store.Queue("my-hook", job); status.State != JobQueued || status.Cmd != "echo hello" || status.Hook != "my-hook" {
+		t.Errorf("Queue must return the queued job status, got %#v", status)
+	}
+	if status, found := store.Get("job-1"); !found || status.State != JobQueued {
+		t.Errorf("Get must return queued jobs, got %#v", status)
+	}
+	if _, found := store.Stream("job-1"); found {
+		t.Error("Stream must not return queued jobs")
+	}
+
+	stream, _, run := store.Start("my-hook", job)
+	if !run || stream == nil {
+		t.Fatal("Start must return the stream of queued jobs")
+	}
+	if found, running := store.Stream("job-1"); !running || found != stream {
+		t.Error("Stream must return the running jobs stream")
+	}
+	if status, err := store.Cancel("job-1", "Cancelled by test"); err == nil || status.State != JobRunning {
+		t.Errorf("Cancel must fail for running jobs, got %#v", status)
+	}
+	store.Finish("job-1", CommandResult{ID: "job-1", Err: "exit status 1", ExitCode: 1, Stdout: []byte("output")})
+	status, found := store.Get("job-1")
+	if !found || status.State != JobFailed || status.Result == nil || status.Result.ExitCode != 1 || status.Result.Stdout != nil {
+		t.Errorf("Get must return the finished job result without output, got %#v", status)
+	}
+	if _, found := store.Stream("job-1"); found || stream.Result() == nil {
+		t.Error("Finish must close the job stream")
+	}
+
+	store.Queue("my-hook", CommandJob{ID: "job-2"})
+	if status, err := store.Cancel("job-2", "Cancelled by test"); err != nil || status.State != JobCancelled {
+		t.Errorf("Cancel must cancel queued jobs, got %#v %v", status, err)
+	}
+	if _, _, run := store.Start("my-hook", CommandJob{ID: "job-2"}); run {
+		t.Error("Start must not run cancelled jobs")
+	}
+	if _, err := store.Cancel("unknown", "Cancelled by test"); err == nil {
+		t.Error("Cancel must fail for unknown jobs")
+	}
+	store.Finish("job-2", CommandResult{ID: "job-2", Cancelled: true})
+
+	if _, _, run := store.Start("my-hook", CommandJob{ID: "job-3"}); !run {
+		t.Error("Start must run jobs not queued through the store")
+	}
+	store.Finish("job-3", CommandResult{ID: "job-3"})
+	if _, found := store.Get("job-1"); found {
+		t.Error("Oldest finished jobs must be removed once limit is reached")
+	}
+	if status, found := store.Get("job-3"); !found || status.State != JobSucceeded {
+		t.Errorf("Get must return finished jobs, got %#v", status)
+	}
+
+	store.Queue("my-hook", CommandJob{ID: "job-4"})
+	store.Reject("my-hook", "job-4")
+	if _, found := store.Get("job-4"); found {
+		t.Error("Rejected jobs must not be stored")
+	}
+	store.Queue("my-hook", CommandJob{ID: "job-5"})
+	store.Drop("my-hook", "job-5", CommandResult{ID: "job-5", Cancelled: true})
+	if status, _ := store.Get("job-5"); status.State != JobCancelled {
+		t.Errorf("Dropped jobs must be cancelled, got %#v", status)
+	}
+	store.Queue("my-hook", CommandJob{ID: "job-6"})
+	if status, err := store.Supersede("job-6", "job-7", false); err != nil || status.State != JobCancelled || status.SupersededBy != "job-7" {
+		t.Errorf("Supersede must cancel queued jobs, got %#v %v", status, err)
+	}
+	if _, err := store.Supersede("job-6", "job-8", false); err == nil {
+		t.Error("Supersede must fail for superseded jobs")
+	}
+	store.Finish("job-6", CommandResult{ID: "job-6", Cancelled: true})
+	store.Queue("my-hook", CommandJob{ID: "job-7"})
+	_, cancel, _ := store.Start("my-hook", CommandJob{ID: "job-7"})
+	if status, err := store.Supersede("job-7", "job-8", false); err == nil || status.State != JobRunning {
+		t.Errorf("Supersede must not stop running jobs unless running is set, got %#v", status)
+	}
+	if status, err := store.Supersede("job-7", "job-8", true); err != nil || status.State != JobRunning || status.Reason != "Superseded by job job-8" {
+		t.Errorf("Supersede must stop running jobs when running is set, got %#v %v", status, err)
+	}
+	select {
+	case <-cancel:
+	default:
+		t.Error("Supersede must close the running job cancel channel")
+	}
+	store.Finish("job-7", CommandResult{ID: "job-7", Cancelled: true})
+
+	stats := store.Stats()["my-hook"]
+	if stats.Queued != 5 || stats.Rejected != 1 || stats.Dropped != 1 ||
+		stats.Finished[JobFailed] != 1 || stats.Finished[JobCancelled] != 4 || stats.Finished[JobSucceeded] != 1 {
+		t.Errorf("Stats do not match the jobs received, got %#v", stats)
+	}
+
+	var disabled *JobStore
+	disabled.Queue("my-hook", job)
+	if _, _, run := disabled.Start("my-hook", job); !run {
+		t.Error("Disabled stores must run every job")
+	}
+	disabled.Finish("job-1", CommandResult{})
+	if _, found := disabled.Get("job-1"); found {
+		t.Error("Disabled stores must not track jobs")
+	}
+	if _, err := disabled.Cancel("job-1", "Cancelled by test"); err == nil {
+		t.Error("Disabled stores must not cancel jobs")
+	}
+	disabled.Reject("my-hook", "job-1")
+	disabled.Drop("my-hook", "job-1", CommandResult{})
+	if len(disabled.Stats()) != 0 {
+		t.Error("Disabled stores must not count jobs")
+	}
+}
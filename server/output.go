@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// defaultMaxOutput is the number of bytes of each hook command output stream stored at the command log
+const defaultMaxOutput = 1 << 20
+
+// outputWaitDelay is the time the command output is still read once the command exits, as descendants
+// which left its process group (i.e.: daemons) may keep the output pipes open and are not killed with it
+const outputWaitDelay = 2 * time.Second
+
+// outputBuffer is an io.Writer storing up to limit bytes of a command output, when more data is written
+// it keeps the first and the last limit/2 bytes. A limit of 0 or below stores the whole output
+// It is safe for concurrent use
+type outputBuffer struct {
+	mutex sync.Mutex
+	limit int
+	head  []byte
+	tail  []byte
+	total int
+}
+
+// newOutputBuffer creates an outputBuffer storing up to limit bytes
+func newOutputBuffer(limit int) *outputBuffer {
+	return &outputBuffer{limit: limit}
+}
+
+// Write implements io.Writer, it never fails
+func (b *outputBuffer) Write(p []byte) (n int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.total += len(p)
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return len(p), nil
+	}
+	headLimit := b.limit - b.limit/2
+	tailLimit := b.limit / 2
+	rest := p
+	if room := headLimit - len(b.head); room > 0 {
+		if room > len(rest) {
+			room = len(rest)
+		}
+		b.head = append(b.head, rest[:room]...)
+		rest = rest[room:]
+	}
+	if len(rest) >= tailLimit {
+		b.tail = append(b.tail[:0], rest[len(rest)-tailLimit:]...)
+	} else {
+		b.tail = append(b.tail, rest...)
+		if len(b.tail) > tailLimit {
+			b.tail = append(b.tail[:0], b.tail[len(b.tail)-tailLimit:]...)
+		}
+	}
+	return len(p), nil
+}
+
+// Truncated reports whether part of the output was discarded
+func (b *outputBuffer) Truncated() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.total > len(b.head)+len(b.tail)
+}
+
+// Bytes returns the stored output, when it was truncated a line with the number of
+// discarded bytes is placed between its first and last bytes
+func (b *outputBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	output := append([]byte{}, b.head...)
+	if discarded := b.total - len(b.head) - len(b.tail); discarded > 0 {
+		output = append(output, fmt.Sprintf("\n... [%d bytes truncated] ...\n", discarded)...)
+	}
+	return append(output, b.tail...)
+}
+
+// outputPipes connects a command standard output and error to pipes copied into writers, unlike the
+// pipes created by exec.Cmd they can be closed while a process still keeps them open
+type outputPipes struct {
+	readers []*os.File
+	writers []*os.File
+	copied  sync.WaitGroup
+}
+
+// newOutputPipes creates the pipes used as command stdout and stderr and starts copying them into
+// stdout and stderr
+// It returns error if the pipes cannot be created
+func newOutputPipes(command *exec.Cmd, stdout, stderr io.Writer) (pipes *outputPipes, err error) {
+	pipes = new(outputPipes)
+	for _, output := range []io.Writer{stdout, stderr} {
+		reader, writer, pipeErr := os.Pipe()
+		if pipeErr != nil {
+			pipes.closeWriters()
+			pipes.wait(0)
+			return nil, pipeErr
+		}
+		pipes.readers = append(pipes.readers, reader)
+		pipes.writers = append(pipes.writers, writer)
+		pipes.copied.Add(1)
+		go func(output io.Writer, reader *os.File) {
+			defer pipes.copied.Done()
+			io.Copy(output, reader)
+		}(output, reader)
+	}
+	command.Stdout, command.Stderr = pipes.writers[0], pipes.writers[1]
+	return
+}
+
+// closeWriters closes the githook side of the pipes write ends, it must be called once the command
+// is started (or failed to start) so the copies end when the command closes its own
+func (p *outputPipes) closeWriters() {
+	for _, writer := range p.writers {
+		writer.Close()
+	}
+}
+
+// wait waits for the output to be copied, the pipes are closed after delay if any
+// process still keeps them open
+func (p *outputPipes) wait(delay time.Duration) {
+	copied := make(chan struct{})
+	go func() {
+		p.copied.Wait()
+		close(copied)
+	}()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-copied:
+	case <-timer.C:
+	}
+	for _, reader := range p.readers {
+		reader.Close()
+	}
+	<-copied
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	testCases := []struct {
+		limit     int
+		writes    []string
+		expected  string
+		truncated bool
+	}{
+		{0, []string{"hello", " ", "world"}, "hello world", false},
+		{0, []string{}, "", false},
+		{11, []string{"hello world"}, "hello world", false},
+		{11, []string{"hello", " ", "world"}, "hello world", false},
+		{6, []string{"hello world"}, "hel\n... [5 bytes truncated] ...\nrld", true},
+		{6, []string{"hello", " ", "world"}, "hel\n... [5 bytes truncated] ...\nrld", true},
+		{6, []string{"h", "e", "l", "l", "o", " ", "w", "o", "r", "l", "d"}, "hel\n... [5 bytes truncated] ...\nrld", true},
+		{5, []string{"hello world"}, "hel\n... [6 bytes truncated] ...\nld", true},
+		{1, []string{"hello"}, "h\n... [4 bytes truncated] ...\n", true},
+	}
+
+	for i, test := range testCases {
+		buffer := newOutputBuffer(test.limit)
+		for _, w := range test.writes {
+			if n, err := buffer.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%02d. outputBuffer Write should not fail, got %d %v", i, n, err)
+			}
+		}
+		if got := string(buffer.Bytes()); got != test.expected {
+			t.Errorf("%02d. outputBuffer should contain %q, got %q", i, test.expected, got)
+		}
+		if buffer.Truncated() != test.truncated {
+			t.Errorf("%02d. outputBuffer truncated should be %v", i, test.truncated)
+		}
+	}
+
+	buffer := newOutputBuffer(1000)
+	for i := 0; i < 10000; i++ {
+		buffer.Write([]byte(strings.Repeat("x", 100)))
+	}
+	if len(buffer.head) != 500 || len(buffer.tail) != 500 || cap(buffer.tail) > 2000 {
+		t.Errorf("outputBuffer must not grow over its limit, got head %d and tail %d (%d)", len(buffer.head), len(buffer.tail), cap(buffer.tail))
+	}
+}
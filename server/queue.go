@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+// Overflow policies applied when a hook queue is full, see Hook.Overflow
+const (
+	OverflowReject     = "reject"
+	OverflowDropOldest = "drop_oldest"
+	OverflowBlock      = "block"
+)
+
+// defaultOverflowTimeout is the number of seconds the block overflow policy waits for room in the queue,
+// and the Retry-After seconds of the rejected requests
+const defaultOverflowTimeout = 10
+
+// errQueueFull is returned when a job cannot be enqueued because the queue is full
+var errQueueFull = errors.New("Queue is full")
+
+// QueueStatus holds the number of jobs waiting at a hook queue and its capacity
+type QueueStatus struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+// queueStatus returns the QueueStatus of queue
+func queueStatus(queue chan CommandJob) *QueueStatus {
+	return &QueueStatus{Depth: len(queue), Capacity: cap(queue)}
+}
+
+// enqueue sends job to queue applying the overflow policy when it is full: reject fails right away,
+// drop_oldest removes the oldest jobs until job fits and block waits up to timeout seconds for room
+// It returns the jobs removed from queue and errQueueFull when job is not enqueued
+func enqueue(queue chan CommandJob, job CommandJob, overflow string, timeout int) (dropped []CommandJob, err error) {
+	select {
+	case queue <- job:
+		return
+	default:
+	}
+	switch overflow {
+	case OverflowDropOldest:
+		if cap(queue) == 0 {
+			return nil, errQueueFull
+		}
+		for {
+			select {
+			case oldest := <-queue:
+				dropped = append(dropped, oldest)
+			default:
+			}
+			select {
+			case queue <- job:
+				return
+			default:
+			}
+		}
+	case OverflowBlock:
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		select {
+		case queue <- job:
+			return
+		case <-timer.C:
+		}
+	}
+	return nil, errQueueFull
+}
+
+// removeCancelled removes the jobs cancelled while they were waiting at queue, which the workers would skip,
+// so they do not take room from new jobs. The order of the rest of jobs is kept, which requires that no
+// other goroutine sends to queue meanwhile
+// It returns the removed jobs
+func removeCancelled(queue chan CommandJob, jobs *JobStore) (cancelled []CommandJob) {
+	var kept []CommandJob
+	for n := len(queue); n > 0; n-- {
+		select {
+		case job := <-queue:
+			if status, _ := jobs.Get(job.ID); status.State == JobCancelled {
+				cancelled = append(cancelled, job)
+			} else {
+				kept = append(kept, job)
+			}
+		default:
+		}
+	}
+	for _, job := range kept {
+		queue <- job
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEnqueue(t *testing.T) {
+	testCases := []struct {
+		capacity int
+		overflow string
+		queued   []string
+		dropped  []string
+		err      bool
+	}{
+		{2, OverflowReject, []string{"job-0", "job-1"}, nil, true},
+		{2, "", []string{"job-0", "job-1"}, nil, true},
+		{2, OverflowDropOldest, []string{"job-1", "new-job"}, []string{"job-0"}, false},
+		{1, OverflowDropOldest, []string{"new-job"}, []string{"job-0"}, false},
+		{0, OverflowDropOldest, nil, nil, true},
+		{2, OverflowBlock, []string{"job-0", "job-1"}, nil, true},
+	}
+
+	for i, test := range testCases {
+		queue := make(chan CommandJob, test.capacity)
+		for j := 0; j < test.capacity; j++ {
+			queue <- CommandJob{ID: "job-" + strconv.Itoa(j)}
+		}
+		dropped, err := enqueue(queue, CommandJob{ID: "new-job"}, test.overflow, 1)
+		if test.err != (err != nil) {
+			t.Errorf("%02d. enqueue error should be %v, got %v", i, test.err, err)
+		}
+		var droppedIDs, queuedIDs []string
+		for _, job := range dropped {
+			droppedIDs = append(droppedIDs, job.ID)
+		}
+		close(queue)
+		for job := range queue {
+			queuedIDs = append(queuedIDs, job.ID)
+		}
+		if fmt.Sprint(droppedIDs) != fmt.Sprint(test.dropped) || fmt.Sprint(queuedIDs) != fmt.Sprint(test.queued) {
+			t.Errorf("%02d. enqueue dropped %v and queued %v, expected %v and %v", i, droppedIDs, queuedIDs, test.dropped, test.queued)
+		}
+	}
+
+	queue := make(chan CommandJob, 1)
+	queue <- CommandJob{ID: "job-0"}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		<-queue
+	}()
+	if _, err := enqueue(queue, CommandJob{ID: "new-job"}, OverflowBlock, 10); err != nil || len(queue) != 1 {
+		t.Errorf("enqueue should wait for room in the queue with block policy, got %v", err)
+	}
+}
+
+func TestRemoveCancelled(t *testing.T) {
+	jobs := NewJobStore(10)
+	queue := make(chan CommandJob, 4)
+	for _, id := range []string{"job-0", "job-1", "job-2", "job-3"} {
+		job := CommandJob{ID: id}
+		jobs.Queue("test", job)
+		queue <- job
+	}
+	jobs.Cancel("job-0", "Cancelled")
+	jobs.Supersede("job-2", "job-3", false)
+
+	var removedIDs, queuedIDs []string
+	for _, job := range removeCancelled(queue, jobs) {
+		removedIDs = append(removedIDs, job.ID)
+	}
+	close(queue)
+	for job := range queue {
+		queuedIDs = append(queuedIDs, job.ID)
+	}
+	if fmt.Sprint(removedIDs) != "[job-0 job-2]" || fmt.Sprint(queuedIDs) != "[job-1 job-3]" {
+		t.Errorf("removeCancelled removed %v and kept %v, expected [job-0 job-2] and [job-1 job-3]", removedIDs, queuedIDs)
+	}
+}
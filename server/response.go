@@ -1,8 +1,10 @@
 package server
 
 // Response represents a server request response
+// Queue holds the hook queue status on hook requests responses
 type Response struct {
-	Status int         `json:"status"`
-	Msg    string      `json:"msg"`
-	Body   interface{} `json:"body,omitempty"`
+	Status int          `json:"status"`
+	Msg    string       `json:"msg"`
+	Body   interface{}  `json:"body,omitempty"`
+	Queue  *QueueStatus `json:"queue,omitempty"`
 }
@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Wiston999/githook/event"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -25,6 +27,7 @@ type Server struct {
 	HooksHandled      map[string]int
 	WorkerChannels    map[string]chan CommandJob
 	CmdLog            CommandLog
+	Jobs              *JobStore
 }
 
 // ListenAndServe set ups everything needed for the server to run and
@@ -40,10 +43,13 @@ func (s *Server) ListenAndServe() (err error) {
 	if s.WorkerChannels == nil {
 		s.WorkerChannels = make(map[string]chan CommandJob)
 	}
+	if s.Jobs == nil {
+		s.Jobs = NewJobStore(s.CmdLogLimit)
+	}
+	s.setCommandLog()
 	if err = s.setHooks(); err != nil {
 		return
 	}
-	s.setCommandLog()
 	s.setAdminEndpoints()
 
 	s.Server.Handler = s.MuxHandler
@@ -57,7 +63,8 @@ func (s *Server) ListenAndServe() (err error) {
 // and closing underlying channels
 func (s *Server) Stop() (err error) {
 	log.Info("Stopping http server with 5 seconds timeout")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	return s.Server.Shutdown(ctx)
 }
 
@@ -97,6 +104,14 @@ func (s *Server) setAdminEndpoints() (err error) {
 		s.MuxHandler.HandleFunc("/admin/cmdlog", JSONRequestMiddleware(CommandLogRESTHandler(s.CmdLog)))
 		s.HooksHandled["/admin/cmdlog"] = 1
 	}
+	if _, ok := s.HooksHandled["/admin/metrics"]; !ok {
+		s.MuxHandler.HandleFunc("/admin/metrics", JSONRequestMiddleware(MetricsHandler(s.WorkerChannels, s.Jobs)))
+		s.HooksHandled["/admin/metrics"] = 1
+	}
+	if _, ok := s.HooksHandled["/admin/jobs/"]; !ok {
+		s.MuxHandler.HandleFunc("/admin/jobs/", JSONRequestMiddleware(JobsHandler(s.Jobs, s.CmdLog)))
+		s.HooksHandled["/admin/jobs/"] = 1
+	}
 	return
 }
 
@@ -112,10 +127,25 @@ func (s *Server) setHooks() (err error) {
 			s.HooksHandled[v.Path] = 1
 			continue
 		}
-		if v.Type != "bitbucket" && v.Type != "github" && v.Type != "gitlab" {
-			log.WithFields(log.Fields{"hook": k}).Warn("Unknown repository type, it must be one of: bitbucket, github or gitlab")
+		parser, parserErr := v.Parser()
+		if parserErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn(parserErr)
+			continue
+		}
+		if _, filterErr := v.Filters.compile(); filterErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid filters: ", filterErr)
 			continue
 		}
+		secret, secretErr := v.LoadSecret()
+		if secretErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Unable to load secret: ", secretErr)
+			continue
+		}
+		if _, validator := parser.(event.Validator); secret != "" && !validator {
+			log.WithFields(log.Fields{"hook": k}).Warn("Repository type ", v.Type, " does not support request validation, secret must not be defined")
+			continue
+		}
+		v.Secret = secret
 		if !strings.HasPrefix(v.Path, "/") || strings.HasPrefix(v.Path, "/admin") {
 			log.WithFields(log.Fields{"hook": k}).Warn("Path must start with / and must not start with /admin")
 			continue
@@ -124,8 +154,45 @@ func (s *Server) setHooks() (err error) {
 			log.WithFields(log.Fields{"hook": k}).Warn("Timeout must be greater than 0, got ", v.Timeout)
 			continue
 		}
-		if len(v.Cmd) == 0 {
-			log.WithFields(log.Fields{"hook": k}).Warn("Cmd must be defined")
+		if v.KillGrace != nil && *v.KillGrace < 0 {
+			log.WithFields(log.Fields{"hook": k}).Warn("Kill grace must be greater than or equal to 0, got ", *v.KillGrace)
+			continue
+		}
+		if v.DedupeWindow < 0 {
+			log.WithFields(log.Fields{"hook": k}).Warn("Dedupe window must be greater than or equal to 0, got ", v.DedupeWindow)
+			continue
+		}
+		if len(v.Cmd) == 0 && v.Script == "" && len(v.Steps) == 0 {
+			log.WithFields(log.Fields{"hook": k}).Warn("Cmd, script or steps must be defined")
+			continue
+		}
+		if stepsErr := v.validateSteps(); stepsErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid steps: ", stepsErr)
+			continue
+		}
+		for _, script := range v.scripts() {
+			if strings.Contains(script, "{{") {
+				log.WithFields(log.Fields{"hook": k}).Warn("Script is not a template, use GITHOOK_* environment variables or cmd positional parameters to access event data")
+			}
+		}
+		if _, tplErr := parseTemplates(v.Cmd); tplErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid cmd template: ", tplErr)
+			continue
+		}
+		if envErr := validateEnv(v.Env); envErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid env: ", envErr)
+			continue
+		}
+		if settingsErr := v.validateCommandSettings(); settingsErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid command settings: ", settingsErr)
+			continue
+		}
+		if overflowErr := v.validateOverflow(); overflowErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid overflow settings: ", overflowErr)
+			continue
+		}
+		if coalesceErr := v.validateCoalesce(); coalesceErr != nil {
+			log.WithFields(log.Fields{"hook": k}).Warn("Invalid coalesce settings: ", coalesceErr)
 			continue
 		}
 		if v.Concurrency == 0 {
@@ -133,9 +200,9 @@ func (s *Server) setHooks() (err error) {
 			v.Concurrency = 1
 		}
 		s.WorkerChannels[k] = make(chan CommandJob, s.WorkerChannelSize)
-		s.MuxHandler.HandleFunc(v.Path, JSONRequestMiddleware(RepoRequestHandler(s.CmdLog, s.WorkerChannels[k], k, v)))
+		s.MuxHandler.HandleFunc(v.Path, JSONRequestMiddleware(RepoRequestHandler(s.CmdLog, s.WorkerChannels[k], s.Jobs, k, v)))
 		for i := 0; i < v.Concurrency; i++ {
-			go CommandWorker(k, s.WorkerChannels[k], s.CmdLog)
+			go CommandWorker(k, s.WorkerChannels[k], s.CmdLog, s.Jobs)
 		}
 		log.WithFields(log.Fields{
 			"count": v.Concurrency,
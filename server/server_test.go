@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+
+	"github.com/Wiston999/githook/event"
 )
 
 func TestListenAndServe(t *testing.T) {
@@ -65,6 +67,12 @@ func TestSetHooks(t *testing.T) {
 		t.Errorf("setHooks must error when no hooks are configured")
 	}
 
+	if _, found := event.Lookup("TestSetHooks"); !found {
+		event.Register("TestSetHooks", event.ParserFunc(func(r *http.Request) (*event.RepoEvent, error) {
+			return &event.RepoEvent{Branch: "master"}, nil
+		}))
+	}
+
 	hooks := make(map[string]Hook)
 	hooks["test1"] = Hook{Type: "github", Path: "/github1", Cmd: []string{"true"}, Timeout: 500}
 	hooks["test2"] = Hook{Type: "github", Path: "/github2", Cmd: []string{"true"}, Timeout: 500}
@@ -79,6 +87,34 @@ func TestSetHooks(t *testing.T) {
 	hooks["test11"] = Hook{Type: "bitbucket", Path: "/invalid2", Cmd: []string{"true"}, Timeout: -10}
 	hooks["test12"] = Hook{Type: "bitbucket", Path: "/invalid2", Cmd: []string{"true"}, Timeout: 10, Concurrency: -10}
 	hooks["test13"] = Hook{Type: "bitbucket", Path: "/admin", Cmd: []string{"true"}, Timeout: 500}
+	hooks["test14"] = Hook{Type: "github", Path: "/github3", Cmd: []string{"true"}, Timeout: 500, Secret: "secret"}
+	hooks["test15"] = Hook{Type: "github", Path: "/github4", Cmd: []string{"true"}, Timeout: 500, SecretFile: "/notfound"}
+	hooks["test16"] = Hook{Type: "bitbucket", Path: "/bitbucket2", Cmd: []string{"true"}, Timeout: 500, Secret: "secret"}
+	hooks["test17"] = Hook{Type: "TestSetHooks", Path: "/custom1", Cmd: []string{"true"}, Timeout: 500}
+	hooks["test18"] = Hook{Type: "TestSetHooks", Path: "/custom2", Cmd: []string{"true"}, Timeout: 500, Secret: "secret"}
+	hooks["test19"] = Hook{Type: "generic", Path: "/generic1", Cmd: []string{"true"}, Timeout: 500, Options: map[string]string{"branch": "$.ref"}}
+	hooks["test20"] = Hook{Type: "generic", Path: "/generic2", Cmd: []string{"true"}, Timeout: 500}
+	hooks["test21"] = Hook{Type: "github", Path: "/github5", Cmd: []string{"true"}, Timeout: 500, Options: map[string]string{"branch": "$.ref"}}
+	hooks["test22"] = Hook{Type: "github", Path: "/github6", Cmd: []string{"true"}, Timeout: 500, Filters: Filters{Branches: []string{"master"}}}
+	hooks["test23"] = Hook{Type: "github", Path: "/github7", Cmd: []string{"true"}, Timeout: 500, Filters: Filters{Branches: []string{"re:(unclosed"}}}
+	hooks["test24"] = Hook{Type: "github", Path: "/github8", Cmd: []string{"echo", "{{.Branch | slugify}}"}, Timeout: 500}
+	hooks["test25"] = Hook{Type: "github", Path: "/github9", Cmd: []string{"echo", "{{.Branch | unknown}}"}, Timeout: 500}
+	hooks["test26"] = Hook{Type: "github", Path: "/github10", Cmd: []string{"true"}, Timeout: 500, Env: map[string]string{"TAG": "{{.Branch}}"}, Stdin: true}
+	hooks["test27"] = Hook{Type: "github", Path: "/github11", Cmd: []string{"true"}, Timeout: 500, Env: map[string]string{"TAG": "{{.Branch"}}
+	hooks["test28"] = Hook{Type: "github", Path: "/github12", Cmd: []string{"true"}, Timeout: 500, Workdir: "/tmp/{{.Branch}}", CreateWorkdir: true, Umask: "022"}
+	hooks["test29"] = Hook{Type: "github", Path: "/github13", Cmd: []string{"true"}, Timeout: 500, Umask: "999"}
+	hooks["test30"] = Hook{Type: "github", Path: "/github14", Cmd: []string{"true"}, Timeout: 500, Workdir: "/this/directory/does/not/exist"}
+	hooks["test31"] = Hook{Type: "github", Path: "/github15", Script: "make deploy | tee deploy.log", Timeout: 500}
+	hooks["test32"] = Hook{Type: "github", Path: "/github16", Script: "echo $1", Cmd: []string{"{{.Branch"}, Timeout: 500}
+	hooks["test33"] = Hook{Type: "github", Path: "/github17", Timeout: 500, Steps: []Step{{Cmd: []string{"git", "fetch"}}, {Script: "make | tee build.log"}}}
+	hooks["test34"] = Hook{Type: "github", Path: "/github18", Timeout: 500, Cmd: []string{"true"}, Steps: []Step{{Cmd: []string{"true"}}}}
+	hooks["test35"] = Hook{Type: "github", Path: "/github19", Timeout: 500, Cmd: []string{"true"}, KillGrace: intPointer(10)}
+	hooks["test36"] = Hook{Type: "github", Path: "/github20", Timeout: 500, Cmd: []string{"true"}, KillGrace: intPointer(-1)}
+	hooks["test37"] = Hook{Type: "github", Path: "/github21", Timeout: 500, Cmd: []string{"true"}, Overflow: OverflowDropOldest}
+	hooks["test38"] = Hook{Type: "github", Path: "/github22", Timeout: 500, Cmd: []string{"true"}, Overflow: "unknown"}
+	hooks["test39"] = Hook{Type: "github", Path: "/github23", Timeout: 500, Cmd: []string{"true"}, DedupeWindow: 3600}
+	hooks["test40"] = Hook{Type: "github", Path: "/github24", Timeout: 500, Cmd: []string{"true"}, DedupeWindow: -1}
+	hooks["test41"] = Hook{Type: "github", Path: "/github25", Timeout: 500, Cmd: []string{"true"}, Filters: Filters{Kinds: []string{"tag-push"}}}
 
 	s.Hooks = hooks
 	s.HooksHandled = make(map[string]int)
@@ -98,6 +134,21 @@ func TestSetHooks(t *testing.T) {
 		"test10": "Timeout must be greater than 0",
 		"test11": "Timeout must be greater than 0",
 		"test13": "/admin is a reserved path",
+		"test15": "Secret file not found",
+		"test18": "Secret not supported by parser",
+		"test20": "Generic parser without options",
+		"test21": "Options not supported by parser",
+		"test23": "Invalid filters",
+		"test25": "Invalid cmd template",
+		"test27": "Invalid env",
+		"test29": "Invalid umask",
+		"test30": "Missing workdir",
+		"test32": "Invalid script arguments template",
+		"test34": "Steps along with cmd",
+		"test36": "Negative kill grace",
+		"test38": "Unknown overflow policy",
+		"test40": "Negative dedupe window",
+		"test41": "Unknown filter kind",
 	}
 
 	hooksHandled := s.HooksHandled
@@ -170,3 +221,8 @@ func TestSetCommandLog(t *testing.T) {
 		t.Errorf("Command Log type is not the expected, got %#v but expected DiskCommandLog", v)
 	}
 }
+
+// intPointer returns a pointer to n, for the optional Hook settings
+func intPointer(n int) *int {
+	return &n
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+)
+
+// streamBufferSize is the number of output chunks buffered for each stream subscriber, slower
+// subscribers are disconnected
+const streamBufferSize = 256
+
+// JobStream is an io.Writer receiving the interleaved output of a running job, it keeps the output
+// written so far (up to defaultMaxOutput bytes) and sends every new chunk to its subscribers
+// It is safe for concurrent use
+type JobStream struct {
+	mutex       sync.Mutex
+	output      *outputBuffer
+	subscribers map[chan []byte]bool
+	result      *CommandResult
+}
+
+// newJobStream creates an empty JobStream
+func newJobStream() *JobStream {
+	return &JobStream{output: newOutputBuffer(defaultMaxOutput), subscribers: make(map[chan []byte]bool)}
+}
+
+// Write implements io.Writer, it never fails
+func (s *JobStream) Write(p []byte) (n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.output.Write(p)
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- append([]byte(nil), p...):
+		default:
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return len(p), nil
+}
+
+// Subscribe returns the output written so far and a channel receiving the next chunks, which is closed
+// when the job finishes or the subscriber does not keep up with the output. The channel is nil when
+// the job has already finished
+func (s *JobStream) Subscribe() (output []byte, chunks chan []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.result != nil {
+		return s.output.Bytes(), nil
+	}
+	chunks = make(chan []byte, streamBufferSize)
+	s.subscribers[chunks] = true
+	return s.output.Bytes(), chunks
+}
+
+// Unsubscribe stops sending chunks to a channel returned by Subscribe
+func (s *JobStream) Unsubscribe(chunks chan []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.subscribers[chunks] {
+		delete(s.subscribers, chunks)
+		close(chunks)
+	}
+}
+
+// Result returns the job result, it is nil while the job is running
+func (s *JobStream) Result() *CommandResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.result
+}
+
+// finish stores the job result and closes the subscribers channels
+func (s *JobStream) finish(result CommandResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.result = &result
+	for subscriber := range s.subscribers {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
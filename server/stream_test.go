@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJobStream(t *testing.T) {
+	stream := newJobStream()
+	stream.Write([]byte("first "))
+	output, chunks := stream.Subscribe()
+	if string(output) != "first " || chunks == nil {
+		t.Fatalf("Subscribe must return the output written so far, got %q", output)
+	}
+	stream.Write([]byte("second"))
+	if chunk := <-chunks; string(chunk) != "second" {
+		t.Errorf("Subscribers must receive the written chunks, got %q", chunk)
+	}
+	if stream.Result() != nil {
+		t.Error("Result must be nil while the job is running")
+	}
+
+	slowOutput, slowChunks := stream.Subscribe()
+	for i := 0; i <= streamBufferSize; i++ {
+		stream.Write([]byte("x"))
+	}
+	received := 0
+	for range slowChunks {
+		received++
+	}
+	if string(slowOutput) != "first second" || received != streamBufferSize {
+		t.Errorf("Slow subscribers must be disconnected once their buffer is full, received %d chunks", received)
+	}
+	if len(chunks) != streamBufferSize {
+		t.Errorf("Subscriber buffer should be full, got %d chunks", len(chunks))
+	}
+
+	stream.finish(CommandResult{ID: "my-job", ExitCode: 1})
+	if _, open := <-chunks; !open {
+		t.Error("Chunks written before finishing must be delivered")
+	}
+	if result := stream.Result(); result == nil || result.ExitCode != 1 {
+		t.Errorf("Result must return the finished job result, got %v", result)
+	}
+	output, chunks = stream.Subscribe()
+	if !strings.HasPrefix(string(output), "first second") || chunks != nil {
+		t.Errorf("Subscribe must not return a channel once the job is finished, got %v", chunks)
+	}
+	stream.Unsubscribe(slowChunks)
+}
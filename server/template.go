@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// shortShaLength is the length of the abbreviated commit SHAs returned by shortSha
+const shortShaLength = 7
+
+var slugifyRegexp = regexp.MustCompile("[^a-z0-9]+")
+
+// templateFuncs holds the functions available to the hook command templates.
+// Functions taking more than one argument receive the value to transform as last
+// argument so they can be used in pipelines, i.e.: `{{.Branch | replace "/" "-"}}`
+var templateFuncs = template.FuncMap{
+	"lower":        strings.ToLower,
+	"upper":        strings.ToUpper,
+	"replace":      replace,
+	"regexReplace": regexReplace,
+	"trimPrefix":   trimPrefix,
+	"trimSuffix":   trimSuffix,
+	"shortSha":     shortSha,
+	"default":      defaultValue,
+	"env":          os.Getenv,
+	"quote":        strconv.Quote,
+	"slugify":      slugify,
+	"json":         toJSON,
+	"now":          time.Now,
+	"date":         formatDate,
+}
+
+// parseTemplates parses each element of cmd as a template using templateFuncs
+// It returns the list of parsed templates and an error if any element is not a valid template
+func parseTemplates(cmd []string) (templates []*template.Template, err error) {
+	for i, arg := range cmd {
+		tpl, tplErr := template.New(fmt.Sprintf("cmd-template-%d", i)).Funcs(templateFuncs).Parse(arg)
+		if tplErr != nil {
+			return nil, tplErr
+		}
+		templates = append(templates, tpl)
+	}
+	return
+}
+
+// replace replaces all the occurrences of old by new in s
+func replace(old, new, s string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+// regexReplace replaces all the matches of expr in s by repl, repl can reference capture groups as $1
+func regexReplace(expr, repl, s string) (string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// trimPrefix removes prefix from s if present
+func trimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// trimSuffix removes suffix from s if present
+func trimSuffix(suffix, s string) string {
+	return strings.TrimSuffix(s, suffix)
+}
+
+// shortSha abbreviates a commit SHA to its first 7 characters
+func shortSha(sha string) string {
+	if len(sha) > shortShaLength {
+		return sha[:shortShaLength]
+	}
+	return sha
+}
+
+// defaultValue returns value unless it is empty (nil, zero or with length 0), def is returned otherwise
+func defaultValue(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	case reflect.Bool:
+		if !v.Bool() {
+			return def
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() == 0 {
+			return def
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() == 0 {
+			return def
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.Float() == 0 {
+			return def
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return def
+		}
+	}
+	return value
+}
+
+// slugify lowercases s and replaces every sequence of non alphanumeric characters by a single dash,
+// i.e.: `Feature/Foo_Bar` becomes `feature-foo-bar`
+func slugify(s string) string {
+	return strings.Trim(slugifyRegexp.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// toJSON encodes value as a JSON document
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// formatDate formats value using Go's time layout, value can be a time.Time or an RFC3339 string
+// such as the commit timestamps sent by the providers
+func formatDate(layout string, value interface{}) (string, error) {
+	switch t := value.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(layout), nil
+	}
+	return "", fmt.Errorf("Unable to format date of type %T", value)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Wiston999/githook/event"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	os.Setenv("GITHOOK_TEMPLATE_TEST", "from-env")
+	defer os.Unsetenv("GITHOOK_TEMPLATE_TEST")
+
+	repoEvent := event.RepoEvent{
+		Branch:  "Feature/Foo_Bar",
+		Commit:  "eddf11a4056b1abc8002c005ddc0a20cd5f1038a",
+		Ref:     "refs/heads/Feature/Foo_Bar",
+		Commits: []event.Commit{{ID: "eddf11a", Timestamp: "2017-12-24T13:20:02+01:00"}},
+		Payload: map[string]interface{}{"number": 42.0, "labels": []interface{}{"bug", "ui"}},
+	}
+
+	testCases := []struct {
+		tpl      string
+		expected string
+		err      bool
+	}{
+		{"{{.Branch | lower}}", "feature/foo_bar", false},
+		{"{{.Branch | upper}}", "FEATURE/FOO_BAR", false},
+		{`{{.Branch | replace "/" "-"}}`, "Feature-Foo_Bar", false},
+		{`{{.Branch | regexReplace "^([A-Za-z]+)/.*$" "$1"}}`, "Feature", false},
+		{`{{.Branch | regexReplace "(" ""}}`, "", true},
+		{`{{.Ref | trimPrefix "refs/heads/"}}`, "Feature/Foo_Bar", false},
+		{`{{.Ref | trimSuffix "_Bar"}}`, "refs/heads/Feature/Foo", false},
+		{"{{.Commit | shortSha}}", "eddf11a", false},
+		{`{{"abc" | shortSha}}`, "abc", false},
+		{`{{.Tag | default "latest"}}`, "latest", false},
+		{`{{.Branch | default "latest"}}`, "Feature/Foo_Bar", false},
+		{`{{.Payload.missing | default "none"}}`, "none", false},
+		{`{{.Payload.number | default 0}}`, "42", false},
+		{`{{env "GITHOOK_TEMPLATE_TEST"}}`, "from-env", false},
+		{`{{env "GITHOOK_TEMPLATE_UNDEFINED"}}`, "", false},
+		{"{{.Branch | quote}}", `"Feature/Foo_Bar"`, false},
+		{"{{.Branch | slugify}}-{{.Commit | shortSha}}", "feature-foo-bar-eddf11a", false},
+		{`{{"--Hello, World!--" | slugify}}`, "hello-world", false},
+		{"{{.Payload.labels | json}}", `["bug","ui"]`, false},
+		{`{{(index .Commits 0).Timestamp | date "2006-01-02"}}`, "2017-12-24", false},
+		{`{{.Branch | date "2006-01-02"}}`, "", true},
+		{`{{now | date "2006"}}`, "", false},
+		{"{{.Branch | unknown}}", "", true},
+	}
+
+	for i, test := range testCases {
+		got, err := TranslateParams([]string{test.tpl}, repoEvent)
+		if test.err {
+			if err == nil {
+				t.Errorf("%02d. Template %s should fail", i, test.tpl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%02d. Template %s should not fail, got %s", i, test.tpl, err)
+			continue
+		}
+		if test.expected != "" && got[0] != test.expected {
+			t.Errorf("%02d. Template %s returned %q, expected %q", i, test.tpl, got[0], test.expected)
+		}
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	testCases := []struct {
+		cmd []string
+		err bool
+	}{
+		{[]string{"echo", "{{.Branch}}"}, false},
+		{[]string{"echo", "{{.Branch | slugify}}"}, false},
+		{[]string{"echo", "{{.Branch"}, true},
+		{[]string{"echo", "{{.Branch | unknown}}"}, true},
+	}
+
+	for i, test := range testCases {
+		_, err := parseTemplates(test.cmd)
+		if test.err && err == nil {
+			t.Errorf("%02d. parseTemplates should fail with %v", i, test.cmd)
+		} else if !test.err && err != nil {
+			t.Errorf("%02d. parseTemplates should not fail with %v, got %s", i, test.cmd, err)
+		}
+	}
+}
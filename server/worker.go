@@ -1,43 +1,163 @@
 package server
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Wiston999/githook/event"
+
 	log "github.com/sirupsen/logrus"
 )
 
 // CommandJob encodes a request to execute a command
+// Options holds the command execution settings, see CommandOptions
+// When Steps is not empty, its commands are run in order instead of Cmd
+// Event and QueuedAt are stored at the CommandResult
+// Finished, when set, is called once the job result is stored, whether it was run or cancelled
 type CommandJob struct {
 	Cmd      []string
 	ID       string
 	Timeout  int
+	Options  CommandOptions
+	Steps    []CommandStep
+	Event    *event.RepoEvent
+	QueuedAt time.Time
 	Response chan CommandResult
+	Finished func()
+}
+
+// CommandStep encodes one command of a multi-step CommandJob
+// Env is added to the job environment variables and a failure does not stop the
+// job when ContinueOnError is set
+type CommandStep struct {
+	Name            string
+	Cmd             []string
+	Timeout         int
+	Env             []string
+	ContinueOnError bool
+}
+
+// String implements fmt.Stringer returning the job command line, steps are joined by &&
+func (job CommandJob) String() string {
+	if len(job.Steps) == 0 {
+		return strings.Join(job.Cmd, " ")
+	}
+	steps := make([]string, 0, len(job.Steps))
+	for _, step := range job.Steps {
+		steps = append(steps, strings.Join(step.Cmd, " "))
+	}
+	return strings.Join(steps, " && ")
+}
+
+// runJob executes job command, or its steps in order. Steps results are stored at the job
+// result Steps field and the remaining steps are skipped when a step without ContinueOnError fails or
+// the job is cancelled
+// It returns the job CommandResult
+func runJob(job CommandJob) (result CommandResult) {
+	if len(job.Steps) == 0 {
+		return RunCommandWithOptions(job.Cmd, job.Timeout, job.Options)
+	}
+	result.ExitCode = 0
+	result.OutputFile = job.Options.OutputFile
+	result.StartedAt = time.Now()
+	defer func() {
+		result.FinishedAt = time.Now()
+		result.Duration = result.FinishedAt.Sub(result.StartedAt).Seconds()
+	}()
+	for _, step := range job.Steps {
+		options := job.Options
+		options.Env = append(append([]string(nil), job.Options.Env...), step.Env...)
+		options.Env = append(options.Env, "GITHOOK_STEP="+step.Name)
+		stepResult := RunCommandWithOptions(step.Cmd, step.Timeout, options)
+		stepResult.ID, stepResult.Step = job.ID, step.Name
+		result.Steps = append(result.Steps, stepResult)
+		if stepResult.Err != "" {
+			log.WithFields(log.Fields{
+				"jobId":           job.ID,
+				"step":            step.Name,
+				"err":             stepResult.Err,
+				"continueOnError": step.ContinueOnError,
+			}).Warn("Step finished unsuccessfully")
+			if !step.ContinueOnError || stepResult.Cancelled {
+				result.Err = fmt.Sprintf("Step %s failed: %s", step.Name, stepResult.Err)
+				result.ExitCode, result.Signal, result.TimedOut = stepResult.ExitCode, stepResult.Signal, stepResult.TimedOut
+				result.Cancelled = stepResult.Cancelled
+				break
+			}
+		}
+	}
+	return
+}
+
+// cancelledResult returns the result of job, received by hookName, cancelled before running as described by status
+func cancelledResult(hookName string, job CommandJob, status JobStatus) CommandResult {
+	return CommandResult{
+		ID:         job.ID,
+		Hook:       hookName,
+		Event:      job.Event,
+		Cmd:        job.Cmd,
+		Err:        "Job cancelled: " + status.Reason,
+		ExitCode:   -1,
+		Cancelled:  true,
+		Superseded: status.SupersededBy,
+		QueuedAt:   job.QueuedAt,
+	}
 }
 
 // CommandWorker runs command receiving from jobs channel, it also stores
 // the command execution result into a CommandLog interface
-func CommandWorker(id string, jobs <-chan CommandJob, cmdLog CommandLog) (executed int) {
+// The jobs state and the output of running jobs are tracked at store, jobs cancelled while
+// they were queued are not run and running jobs are stopped when they are superseded
+func CommandWorker(id string, jobs <-chan CommandJob, cmdLog CommandLog, store *JobStore) (executed int) {
 	for job := range jobs {
-		log.WithFields(log.Fields{
-			"worker": id,
-			"jobId":  job.ID,
-			"cmd":    job.Cmd,
-		}).Info("Executing command")
-		cmdResult := RunCommand(job.Cmd, job.Timeout)
-		log.Debug("Execution of ", job.Cmd, " finished ", cmdResult)
-		if cmdResult.Err != nil {
+		stream, cancel, run := store.Start(id, job)
+		var cmdResult CommandResult
+		if run {
 			log.WithFields(log.Fields{
 				"worker": id,
 				"jobId":  job.ID,
-				"err":    cmdResult.Err,
-				"stderr": cmdResult.Stderr,
-			}).Warn("Command finished unsuccessfully")
+				"cmd":    job.String(),
+			}).Info("Executing command")
+			job.Options.Output, job.Options.Cancel = stream, cancel
+			cmdResult = runJob(job)
+			if cmdResult.Cancelled {
+				status, _ := store.Get(job.ID)
+				cmdResult.Superseded = status.SupersededBy
+			}
 		} else {
+			status, _ := store.Get(job.ID)
 			log.WithFields(log.Fields{
 				"worker": id,
 				"jobId":  job.ID,
-				"err":    cmdResult.Err,
+				"reason": status.Reason,
+			}).Info("Skipping cancelled job")
+			cmdResult = cancelledResult(id, job, status)
+		}
+		cmdResult.ID, cmdResult.Hook = job.ID, id
+		cmdResult.Event, cmdResult.QueuedAt = job.Event, job.QueuedAt
+		log.Debug("Execution of ", job.String(), " finished ", cmdResult)
+		if cmdResult.Err != "" {
+			log.WithFields(log.Fields{
+				"worker":   id,
+				"jobId":    job.ID,
+				"err":      cmdResult.Err,
+				"exitCode": cmdResult.ExitCode,
+				"duration": cmdResult.Duration,
+				"stderr":   cmdResult.Stderr,
+			}).Warn("Command finished unsuccessfully")
+		} else {
+			log.WithFields(log.Fields{
+				"worker":   id,
+				"jobId":    job.ID,
+				"duration": cmdResult.Duration,
 			}).Info("Command finished successfully")
 		}
 		cmdLog.AppendResult(cmdResult)
+		store.Finish(job.ID, cmdResult)
+		if job.Finished != nil {
+			job.Finished()
+		}
 		executed++
 		if job.Response != nil {
 			job.Response <- cmdResult
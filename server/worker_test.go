@@ -1,7 +1,12 @@
 package server
 
 import (
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Wiston999/githook/event"
 )
 
 func TestCommandWorker(t *testing.T) {
@@ -58,13 +63,13 @@ func TestCommandWorker(t *testing.T) {
 
 	workChannel := make(chan CommandJob, 100)
 	for i, test := range testCases {
-		workChannel <- CommandJob{Cmd: test.cmd, ID: string(i), Timeout: test.timeout}
+		workChannel <- CommandJob{Cmd: test.cmd, ID: strconv.Itoa(i), Timeout: test.timeout}
 	}
 
 	cmdLog := NewMemoryCommandLog(100)
 	resultChannel := make(chan int)
 	go func(resChan chan int) {
-		resultChannel <- CommandWorker("CommandWorkerTest", workChannel, cmdLog)
+		resultChannel <- CommandWorker("CommandWorkerTest", workChannel, cmdLog, nil)
 	}(resultChannel)
 
 	close(workChannel)
@@ -78,3 +83,131 @@ func TestCommandWorker(t *testing.T) {
 		)
 	}
 }
+
+func TestRunJobSteps(t *testing.T) {
+	testCases := []struct {
+		steps    []CommandStep
+		executed []string
+		err      bool
+	}{
+		{
+			[]CommandStep{
+				{Name: "fetch", Cmd: []string{"echo", "fetch"}, Timeout: 10},
+				{Name: "build", Cmd: []string{"echo", "build"}, Timeout: 10},
+				{Name: "restart", Cmd: []string{"echo", "restart"}, Timeout: 10},
+			},
+			[]string{"fetch", "build", "restart"},
+			false,
+		},
+		{
+			[]CommandStep{
+				{Name: "fetch", Cmd: []string{"echo", "fetch"}, Timeout: 10},
+				{Name: "build", Cmd: []string{"false"}, Timeout: 10},
+				{Name: "restart", Cmd: []string{"echo", "restart"}, Timeout: 10},
+			},
+			[]string{"fetch", "build"},
+			true,
+		},
+		{
+			[]CommandStep{
+				{Name: "lint", Cmd: []string{"false"}, Timeout: 10, ContinueOnError: true},
+				{Name: "build", Cmd: []string{"echo", "build"}, Timeout: 10},
+			},
+			[]string{"lint", "build"},
+			false,
+		},
+		{
+			[]CommandStep{
+				{Name: "env", Cmd: []string{"sh", "-c", "test \"$GITHOOK_STEP $STEP_VAR $JOB_VAR\" = \"env step job\""}, Env: []string{"STEP_VAR=step"}, Timeout: 10},
+			},
+			[]string{"env"},
+			false,
+		},
+	}
+
+	for i, test := range testCases {
+		job := CommandJob{ID: strconv.Itoa(i), Steps: test.steps, Options: CommandOptions{Env: []string{"JOB_VAR=job"}}}
+		result := runJob(job)
+		if test.err && result.Err == "" {
+			t.Errorf("%02d. runJob should fail with %v", i, test.steps)
+		} else if !test.err && result.Err != "" {
+			t.Errorf("%02d. runJob should not fail with %v, got %s", i, test.steps, result.Err)
+		}
+		var executed []string
+		for _, step := range result.Steps {
+			executed = append(executed, step.Step)
+			if step.ID != job.ID {
+				t.Errorf("%02d. Step %s result must have the job ID, got %s", i, step.Step, step.ID)
+			}
+		}
+		if strings.Join(executed, ",") != strings.Join(test.executed, ",") {
+			t.Errorf("%02d. runJob executed steps %v, expected %v", i, executed, test.executed)
+		}
+	}
+}
+
+func TestCommandJobString(t *testing.T) {
+	testCases := []struct {
+		job      CommandJob
+		expected string
+	}{
+		{CommandJob{Cmd: []string{"echo", "master"}}, "echo master"},
+		{CommandJob{Steps: []CommandStep{{Cmd: []string{"git", "fetch"}}, {Cmd: []string{"make"}}}}, "git fetch && make"},
+	}
+
+	for i, test := range testCases {
+		if got := test.job.String(); got != test.expected {
+			t.Errorf("%02d. CommandJob.String returned %s, expected %s", i, got, test.expected)
+		}
+	}
+}
+
+func TestCommandWorkerResult(t *testing.T) {
+	queuedAt := time.Now()
+	repoEvent := &event.RepoEvent{Kind: event.KindPush, Branch: "master"}
+	jobs := make(chan CommandJob, 1)
+	response := make(chan CommandResult, 1)
+	jobs <- CommandJob{Cmd: []string{"false"}, ID: "0", Timeout: 10, Event: repoEvent, QueuedAt: queuedAt, Response: response}
+	close(jobs)
+
+	CommandWorker("my-hook", jobs, NewMemoryCommandLog(1), nil)
+	got := <-response
+	if got.ID != "0" || got.Hook != "my-hook" || got.Event != repoEvent || !got.QueuedAt.Equal(queuedAt) {
+		t.Errorf("CommandWorker result should store the job data, got %#v", got)
+	}
+	if got.ExitCode != 1 || got.Err == "" || got.StartedAt.Before(queuedAt) {
+		t.Errorf("CommandWorker result should store the command status, got %#v", got)
+	}
+}
+
+func TestCommandWorkerSuperseded(t *testing.T) {
+	store := NewJobStore(10)
+	jobs := make(chan CommandJob, 1)
+	response := make(chan CommandResult, 1)
+	job := CommandJob{Cmd: []string{"sleep", "30"}, ID: "job-1", Timeout: 30, Options: CommandOptions{KillGrace: 1}, Response: response}
+	store.Queue("my-hook", job)
+	jobs <- job
+	close(jobs)
+	go CommandWorker("my-hook", jobs, NewMemoryCommandLog(1), store)
+
+	for i := 0; i < 100; i++ {
+		if status, _ := store.Get("job-1"); status.State == JobRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := store.Supersede("job-1", "job-2", true); err != nil {
+		t.Fatal("Running job should be superseded, got", err)
+	}
+	select {
+	case got := <-response:
+		if !got.Cancelled || got.Superseded != "job-2" || got.Err != "Command cancelled" {
+			t.Errorf("CommandWorker result should be superseded, got %#v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Superseded job should be stopped")
+	}
+	if status, _ := store.Get("job-1"); status.State != JobCancelled || status.SupersededBy != "job-2" {
+		t.Errorf("Superseded job must be cancelled, got %#v", status)
+	}
+}